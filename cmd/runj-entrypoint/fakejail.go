@@ -0,0 +1,8 @@
+//go:build fakejail
+// +build fakejail
+
+package main
+
+// The fakejail build tag registers the fake jail backend used by the lifecycle
+// tests in cmd/runj.
+import _ "go.sbk.wtf/runj/jail/jailtest"
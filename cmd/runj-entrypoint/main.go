@@ -19,19 +19,39 @@ The above procedure is skipped when secondary processes are started, since there
 is no create/start split involved for these processes and the STDIO of `runj
 extension exec` is used directly.
 
-This program exec(2)s to jexec(8), which is then responsible for jail_attach(2)
-and another exec(2) into the final target program.  The sequence of `exec(2)`
-preserves the PID so that it can be the target of a future invocation of `runj
-kill`.
+This program then enters the jail through the jail backend chosen by `runj
+create`.  The native backend calls jail_attach(2) and exec(2)s into the target
+program directly, while the jail8 backend exec(2)s to jexec(8), which is then
+responsible for jail_attach(2) and another exec(2) into the final target
+program.  The sequence of `exec(2)` preserves the PID so that it can be the
+target of a future invocation of `runj kill`.
+
+Failures are reported to runj as JSON through a status pipe, which is closed
+once STDIO is set up for the init process, or through the exec fifo.  Both are
+closed on exec(2); for secondary processes, the status pipe stays open until
+then.  The native backend reports every failure to exec the target program
+this way.  The jail8 backend looks the target program up in the jail's root
+filesystem before the exec(2) to jexec(8), but a later failure, like jexec(8)
+being unable to exec a program in an unsupported format, is only seen as the
+exit status of the process.
+
+runj also uses this program to signal processes in a jail.  Invoked as
+`runj-entrypoint --kill JAIL-ID PID SIGNAL`, it attaches to the jail and sends
+the signal from inside, where a pid reused by a process on the host cannot be
+reached.
 */
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"strconv"
 
+	"go.sbk.wtf/runj/jail"
+
 	"github.com/containerd/console"
 	"golang.org/x/sys/unix"
 )
@@ -47,7 +67,6 @@ func main() {
 var usage = errors.New("usage: runj-entrypoint JAIL-ID FIFO-PATH PROGRAM [ARGS...]")
 
 const (
-	jexecPath        = "/usr/sbin/jexec"
 	consoleSocketEnv = "__RUNJ_CONSOLE_SOCKET"
 
 	// skipExecFifo signals that the exec fifo sync procedure should be skipped
@@ -55,6 +74,9 @@ const (
 )
 
 func _main() (int, error) {
+	if len(os.Args) > 1 && os.Args[1] == jail.KillArg {
+		return killMain(os.Args[2:])
+	}
 	if len(os.Args) < 4 {
 		return 1, usage
 	}
@@ -62,11 +84,33 @@ func _main() (int, error) {
 	fifoPath := os.Args[2]
 	argv := os.Args[3:]
 
-	if err := setupConsole(); err != nil {
-		return 2, err
+	status, err := openStatus()
+	if err != nil {
+		return 1, err
+	}
+
+	backend, err := jail.GetBackend(os.Getenv(jail.BackendEnv))
+	if err != nil {
+		return 1, report(status, jail.EntrypointErrorExec, err)
+	}
+	os.Unsetenv(jail.BackendEnv)
+
+	consolePath, err := setupConsole()
+	if err != nil {
+		return 2, report(status, jail.EntrypointErrorConsole, err)
 	}
 
 	if fifoPath != skipExecFifo {
+		// `runj create` returns once the status pipe is closed; later
+		// failures are reported to `runj start` through the fifo
+		if status != nil {
+			if consolePath != "" {
+				if err := json.NewEncoder(status).Encode(&jail.EntrypointStatus{ConsolePath: consolePath}); err != nil {
+					return 2, err
+				}
+			}
+			status.Close()
+		}
 		// Block until `runj start` is invoked
 		fifofd, err := unix.Open(fifoPath, unix.O_WRONLY|unix.O_CLOEXEC, 0)
 		if err != nil {
@@ -75,39 +119,127 @@ func _main() (int, error) {
 		if _, err := unix.Write(fifofd, []byte("0")); err != nil {
 			return 4, fmt.Errorf("failed to write to fifo: %w", err)
 		}
+		status = os.NewFile(uintptr(fifofd), fifoPath)
+	}
+
+	// the target program keeps this process's pid, which socket-activated
+	// programs check against LISTEN_PID
+	if os.Getenv(jail.ListenFDsEnv) != "" {
+		os.Setenv(jail.ListenPIDEnv, strconv.Itoa(os.Getpid()))
 	}
 
-	// call unix.Exec (which is execve(2)) to replace this process with the jexec
-	if err := unix.Exec(jexecPath, append([]string{"jexec", jid}, argv...), unix.Environ()); err != nil {
-		return 6, fmt.Errorf("failed to exec: %w", err)
+	// replace this process with the target program inside the jail
+	if err := backend.Exec(jid, argv, unix.Environ()); err != nil {
+		return 6, report(status, execErrorKind(err), fmt.Errorf("failed to exec: %w", err))
+	}
+	return 0, nil
+}
+
+// killMain signals processes in a jail for jail.Kill.
+func killMain(args []string) (int, error) {
+	if len(args) != 3 {
+		return 1, errors.New("usage: runj-entrypoint --kill JAIL-ID PID SIGNAL")
+	}
+	pid, err := strconv.Atoi(args[1])
+	if err != nil {
+		return 1, fmt.Errorf("bad pid: %w", err)
+	}
+	signal, err := strconv.Atoi(args[2])
+	if err != nil {
+		return 1, fmt.Errorf("bad signal: %w", err)
+	}
+	if err := jail.KillFromJail(args[0], pid, unix.Signal(signal)); err != nil {
+		return 1, err
 	}
 	return 0, nil
 }
 
-func setupConsole() error {
+// openStatus returns the status pipe passed by runj, if any.  It is closed on
+// exec so that runj sees EOF when the program starts.
+func openStatus() (*os.File, error) {
+	fdArg := os.Getenv(jail.StatusFDEnv)
+	if fdArg == "" {
+		return nil, nil
+	}
+	os.Unsetenv(jail.StatusFDEnv)
+	fd, err := strconv.Atoi(fdArg)
+	if err != nil {
+		return nil, fmt.Errorf("status: bad fd: %w", err)
+	}
+	unix.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), "status"), nil
+}
+
+// report sends err to runj in an EntrypointStatus and returns it.
+func report(status *os.File, kind jail.EntrypointErrorKind, err error) error {
+	if status == nil {
+		return err
+	}
+	s := &jail.EntrypointStatus{Error: &jail.EntrypointError{Kind: kind, Message: err.Error()}}
+	if encodeErr := json.NewEncoder(status).Encode(s); encodeErr != nil {
+		fmt.Fprintln(os.Stderr, "failed to report error:", encodeErr)
+	}
+	return err
+}
+
+// execErrorKind classifies a failure to exec the program.
+func execErrorKind(err error) jail.EntrypointErrorKind {
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return jail.EntrypointErrorNotFound
+	case errors.Is(err, os.ErrPermission):
+		return jail.EntrypointErrorPermission
+	}
+	return jail.EntrypointErrorExec
+}
+
+// setupConsole allocates a pseudoterminal for the process if runj passed a
+// console socket, sends the master to the socket, and returns the path of the
+// slave, which becomes the process's stdio.
+func setupConsole() (string, error) {
 	socketFdArg := os.Getenv(consoleSocketEnv)
 	if socketFdArg == "" {
-		return nil
+		return "", nil
 	}
 	os.Unsetenv(consoleSocketEnv)
+	size := os.Getenv(jail.ConsoleSizeEnv)
+	os.Unsetenv(jail.ConsoleSizeEnv)
+	raw := os.Getenv(jail.ConsoleRawEnv) != ""
+	os.Unsetenv(jail.ConsoleRawEnv)
 	socketFd, err := strconv.Atoi(socketFdArg)
 	if err != nil {
-		return fmt.Errorf("console: bad socket fd: %w", err)
+		return "", fmt.Errorf("console: bad socket fd: %w", err)
 	}
 	socket := os.NewFile(uintptr(socketFd), "console-socket")
-	// TODO clear env variable
 	defer socket.Close()
 
 	pty, slavePath, err := console.NewPty()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer pty.Close()
 
+	// the size and mode are applied before the master is sent so that the
+	// process never observes the defaults
+	if size != "" {
+		var ws console.WinSize
+		if _, err := fmt.Sscanf(size, "%dx%d", &ws.Height, &ws.Width); err != nil {
+			return "", fmt.Errorf("console: bad size %q: %w", size, err)
+		}
+		if err := pty.Resize(ws); err != nil {
+			return "", fmt.Errorf("console: failed to set size: %w", err)
+		}
+	}
+	if raw {
+		if err := pty.SetRaw(); err != nil {
+			return "", fmt.Errorf("console: failed to set raw mode: %w", err)
+		}
+	}
+
 	if err := SendFd(socket, pty.Name(), pty.Fd()); err != nil {
-		return err
+		return "", err
 	}
-	return dupStdio(slavePath)
+	return slavePath, dupStdio(slavePath)
 }
 
 // dupStdio opens the slavePath for the console and dups the fds to the current
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 
+	"go.sbk.wtf/runj/containerlog"
 	"go.sbk.wtf/runj/jail"
 	"go.sbk.wtf/runj/oci"
 	"go.sbk.wtf/runj/runtimespec"
 	"go.sbk.wtf/runj/state"
 
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -77,10 +81,86 @@ command(s) that get executed on start, edit the args parameter of the spec.`,
 		`path to an AF_UNIX socket which will receive a
 file descriptor referencing the master end of
 the console's pseudoterminal`)
+	devfsRuleset := create.Flags().Int(
+		"devfs-ruleset",
+		jail.DefaultDevfsRuleset,
+		"devfs(8) ruleset to apply to the jail's /dev")
+	backendFlag := create.Flags().String(
+		"jail-backend",
+		jail.DefaultBackend,
+		`how jails are created and entered: "native" uses
+jail_set(2) and jail_attach(2), "jail8" uses jail(8)
+and jexec(8)`)
+	monitor := create.Flags().Bool(
+		"monitor",
+		false,
+		`start a monitor process that reaps the container's
+init process and records its exit status, which
+enables "runj wait"`)
+	preserveFDs := create.Flags().Int(
+		"preserve-fds",
+		0,
+		`pass N additional file descriptors to the
+container process, starting at fd 3`)
+	containerLog := create.Flags().String(
+		"container-log",
+		"",
+		`write the container's stdout and stderr to this
+file instead of runj's stdio; implies --monitor`)
+	containerLogFormat := create.Flags().String(
+		"container-log-format",
+		string(containerlog.FormatCRI),
+		`format of the container log: "cri" or "json"`)
+	containerLogMaxSize := create.Flags().String(
+		"container-log-max-size",
+		"0",
+		`rotate the container log when it reaches this
+size, like "10m" (0 disables rotation)`)
+	containerLogMaxFiles := create.Flags().Int(
+		"container-log-max-files",
+		1,
+		`number of container log files to keep, including
+the current file`)
+	configModeFlag := create.Flags().String(
+		"config-mode",
+		string(oci.ConfigModePermissive),
+		`how to handle config.json fields that runj cannot
+honour: "permissive" ignores them, "warn" logs them,
+and "strict" refuses to create the container.  When
+not set, the `+oci.ConfigModeAnnotation+`
+annotation is used`)
 	create.RunE = func(cmd *cobra.Command, args []string) (err error) {
 		disableUsage(cmd)
 		id := args[0]
 		bundle := args[1]
+		// validate the config before any state is created so that an invalid
+		// config leaves nothing behind
+		var configData []byte
+		configData, err = ioutil.ReadFile(filepath.Join(bundle, oci.ConfigFileName))
+		if err != nil {
+			return err
+		}
+		if err = oci.Validate(configData); err != nil {
+			return err
+		}
+		var mode oci.ConfigMode
+		mode, err = configMode(cmd, *configModeFlag, configData)
+		if err != nil {
+			return err
+		}
+		if err = oci.CheckUnsupportedFields(configData, mode); err != nil {
+			return err
+		}
+		var backend jail.Backend
+		backend, err = jail.GetBackend(*backendFlag)
+		if err != nil {
+			return err
+		}
+		var logConfig containerlog.Config
+		logConfig, err = containerLogConfig(*containerLog, *containerLogFormat, *containerLogMaxSize, *containerLogMaxFiles)
+		if err != nil {
+			return err
+		}
 		var s *state.State
 		s, err = state.Create(id, bundle)
 		if err != nil {
@@ -88,9 +168,30 @@ the console's pseudoterminal`)
 		}
 		defer func() {
 			if err == nil {
-				s.Status = state.StatusCreated
-				err = s.Save()
-			} else {
+				// the monitor may already have recorded an exit or an OOM,
+				// so only the fields owned by create are set
+				err = state.Update(id, func(saved *state.State) error {
+					saved.Backend = s.Backend
+					saved.Annotations = s.Annotations
+					saved.ConfigDigest = s.ConfigDigest
+					saved.Rootfs = s.Rootfs
+					saved.JID = s.JID
+					saved.ConsolePath = s.ConsolePath
+					if saved.Status == state.StatusCreating {
+						saved.Status = state.StatusCreated
+						saved.PID = s.PID
+					}
+					return nil
+				})
+			}
+			if err != nil {
+				if s.JID != 0 {
+					// the jail and any processes started in it would
+					// otherwise outlive the state that refers to them
+					if derr := backend.Destroy(cmd.Context(), id); derr != nil {
+						logrus.WithError(derr).WithField("id", id).Warn("create: failed to destroy jail")
+					}
+				}
 				state.Remove(id)
 			}
 		}()
@@ -103,6 +204,9 @@ the console's pseudoterminal`)
 		if err != nil {
 			return err
 		}
+		s.Backend = backend.Name()
+		s.Annotations = ociConfig.Annotations
+		s.ConfigDigest = digest.FromBytes(configData).String()
 		rootPath := filepath.Join(bundle, "root")
 		if ociConfig != nil && ociConfig.Root != nil && ociConfig.Root.Path != "" {
 			rootPath = ociConfig.Root.Path
@@ -110,6 +214,19 @@ the console's pseudoterminal`)
 				rootPath = filepath.Join(bundle, rootPath)
 			}
 		}
+		s.Rootfs, err = resolveRootfs(rootPath)
+		if err != nil {
+			return err
+		}
+		// report a missing executable now rather than as an exec failure
+		// at start.  This only validates the config: the path is looked
+		// up again inside the jail when the process is started, which
+		// finds the same executable unless the root filesystem changes in
+		// the meantime.
+		_, err = oci.LookPath(s.Rootfs, ociConfig.Process)
+		if err != nil {
+			return err
+		}
 		// console socket validation
 		if ociConfig.Process.Terminal {
 			if *consoleSocket == "" {
@@ -125,26 +242,120 @@ the console's pseudoterminal`)
 		} else if *consoleSocket != "" {
 			return errors.New("console-socket provided but Process.Terminal is false")
 		}
-		var confPath string
-		confPath, err = jail.CreateConfig(id, rootPath)
+		if ociConfig.Process.Terminal && logConfig.Path != "" {
+			return errors.New("container-log cannot be used when Process.Terminal is true")
+		}
+		s.JID, err = backend.Create(cmd.Context(), &jail.Config{
+			Name:         id,
+			Root:         rootPath,
+			DevfsRuleset: *devfsRuleset,
+			Parameters:   jail.ParametersFromAnnotations(ociConfig.Annotations),
+		})
 		if err != nil {
 			return err
 		}
-		if err := jail.CreateJail(cmd.Context(), confPath); err != nil {
-			return err
+
+		if *monitor || *containerLog != "" {
+			// the monitor starts "runj-entrypoint" as its own child
+			var status *monitorStatus
+			status, err = startMonitor(cmd, id, &monitorOptions{
+				backend:       s.Backend,
+				consoleSocket: *consoleSocket,
+				listenFDs:     jail.ListenFDs(),
+				preserveFDs:   *preserveFDs,
+				log:           logConfig,
+			})
+			if err != nil {
+				return err
+			}
+			s.PID = status.PID
+			s.ConsolePath = status.ConsolePath
+			return nil
 		}
 
 		// Setup and start the "runj-entrypoint" helper program in order to
 		// get the container STDIO hooked up properly.
-		var entrypoint *exec.Cmd
-		entrypoint, err = jail.SetupEntrypoint(id, true, ociConfig.Process.Args, ociConfig.Process.Env, *consoleSocket)
+		var entrypoint *jail.Entrypoint
+		entrypoint, err = jail.SetupEntrypoint(id, s.Backend, true, ociConfig.Process.Args, oci.ProcessEnv(ociConfig.Process), consoleOptions(ociConfig, *consoleSocket), jail.ListenFDs(), *preserveFDs)
 		if err != nil {
 			return err
 		}
 		// the runj-entrypoint pid will become the container process's pid
 		// through a series of exec(2) calls
 		s.PID = entrypoint.Process.Pid
+		s.ConsolePath = entrypoint.ConsolePath
 		return nil
 	}
 	return create
 }
+
+// configMode determines the oci.ConfigMode for a container.  The --config-mode
+// flag takes precedence over the annotation in config.json.
+func configMode(cmd *cobra.Command, flag string, configData []byte) (oci.ConfigMode, error) {
+	if cmd.Flags().Changed("config-mode") {
+		return oci.ParseConfigMode(flag)
+	}
+	spec := &runtimespec.Spec{}
+	if err := json.Unmarshal(configData, spec); err != nil {
+		return "", err
+	}
+	if mode, ok := spec.Annotations[oci.ConfigModeAnnotation]; ok {
+		return oci.ParseConfigMode(mode)
+	}
+	return oci.ConfigModePermissive, nil
+}
+
+// containerLogConfig converts the container log flags to a
+// containerlog.Config.  The Path is empty if no container log was requested.
+func containerLogConfig(path, format, maxSize string, maxFiles int) (containerlog.Config, error) {
+	if path == "" {
+		return containerlog.Config{}, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return containerlog.Config{}, err
+	}
+	f, err := containerlog.ParseFormat(format)
+	if err != nil {
+		return containerlog.Config{}, err
+	}
+	size, err := containerlog.ParseSize(maxSize)
+	if err != nil {
+		return containerlog.Config{}, fmt.Errorf("container-log-max-size: %w", err)
+	}
+	if maxFiles < 1 {
+		return containerlog.Config{}, errors.New("container-log-max-files must be at least 1")
+	}
+	return containerlog.Config{Path: abs, Format: f, MaxSize: size, MaxFiles: maxFiles}, nil
+}
+
+// consoleOptions returns the jail.Console for the container process, or nil if
+// it does not use a terminal.
+func consoleOptions(spec *runtimespec.Spec, socketPath string) *jail.Console {
+	if !spec.Process.Terminal {
+		return nil
+	}
+	console := &jail.Console{
+		SocketPath: socketPath,
+		Raw:        oci.ConsoleRaw(spec.Annotations),
+	}
+	if size := spec.Process.ConsoleSize; size != nil {
+		console.Height = size.Height
+		console.Width = size.Width
+	}
+	return console
+}
+
+// resolveRootfs returns the absolute path of the jail's root filesystem with
+// symlinks resolved.
+func resolveRootfs(rootPath string) (string, error) {
+	abs, err := filepath.Abs(rootPath)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve root filesystem: %w", err)
+	}
+	return resolved, nil
+}
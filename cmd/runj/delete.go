@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
 
 	"go.sbk.wtf/runj/state"
 
@@ -31,7 +30,15 @@ func deleteCommand() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			disableUsage(cmd)
 			id := args[0]
-			running, err := jail.IsRunning(cmd.Context(), id, 0)
+			s, err := state.Load(id)
+			if err != nil {
+				return errors.New("invalid jail id provided")
+			}
+			backend, err := jail.GetBackend(s.Backend)
+			if err != nil {
+				return err
+			}
+			running, err := backend.IsRunning(cmd.Context(), id, 0)
 			if err != nil {
 				return fmt.Errorf("delete: failed to determine if jail is running: %w", err)
 			}
@@ -42,11 +49,7 @@ func deleteCommand() *cobra.Command {
 			if err != nil {
 				return fmt.Errorf("delete: failed to find entrypoint process: %w", err)
 			}
-			confPath := jail.ConfPath(id)
-			if _, err := os.Stat(confPath); err != nil {
-				return errors.New("invalid jail id provided")
-			}
-			err = jail.DestroyJail(cmd.Context(), confPath, id)
+			err = backend.Destroy(cmd.Context(), id)
 			if err != nil {
 				return err
 			}
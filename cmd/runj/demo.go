@@ -161,6 +161,7 @@ func exampleSpec() *runtimespec.Spec {
 		Version: runtimespec.Version,
 		Process: &runtimespec.Process{
 			Args: []string{"sh"},
+			Cwd:  "/",
 		},
 		Root: &runtimespec.Root{
 			Path: "rootfs",
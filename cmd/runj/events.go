@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/state"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// eventsCommand implements the "events" command, which is not part of the OCI
+// spec and is instead patterned against the "events" command from runc.
+//
+// events <container-id>
+//
+// Events are written to stdout as JSON objects separated by newlines, using the
+// same envelope as runc so that existing tooling can consume them.  Stats are
+// reported from rctl(8) resource usage and rctl(8) notifications are received
+// from devd(8).
+func eventsCommand() *cobra.Command {
+	events := &cobra.Command{
+		Use:   "events <container-id>",
+		Short: "Display container events such as state changes, exits, and resource usage",
+		Long: `Display container events such as state changes, exits, and resource usage.
+
+Events are printed as JSON objects separated by newlines.  Resource usage
+requires RACCT to be enabled in the kernel (kern.racct.enable=1).  Resource
+limit notifications are reported for rctl(8) rules using the "devctl" action.`,
+		Args: cobra.ExactArgs(1),
+	}
+	interval := events.Flags().Duration("interval", 5*time.Second, "set the stats collection interval")
+	stats := events.Flags().Bool("stats", false, "display the container's stats then exit")
+	events.RunE = func(cmd *cobra.Command, args []string) error {
+		disableUsage(cmd)
+		id := args[0]
+		if *interval <= 0 {
+			return errors.New("events: interval must be greater than 0")
+		}
+		s, err := state.Load(id)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		if *stats {
+			st, err := jailStats(cmd.Context(), id)
+			if err != nil {
+				return err
+			}
+			return enc.Encode(Event{Type: EventTypeStats, ID: id, Data: st})
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		rctlEvents, err := jail.SubscribeRctl(ctx, id)
+		if err != nil {
+			// a nil channel is never ready, so the select below simply skips
+			// rctl notifications
+			logrus.WithError(err).Warn("events: rctl notifications unavailable")
+			rctlEvents = nil
+		}
+
+		status, err := currentStatus(ctx, s)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(Event{Type: EventTypeState, ID: id, Data: StateEvent{Status: string(status)}}); err != nil {
+			return err
+		}
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case e, ok := <-rctlEvents:
+				if !ok {
+					rctlEvents = nil
+					continue
+				}
+				if err := enc.Encode(rctlEvent(id, e)); err != nil {
+					return err
+				}
+			case <-ticker.C:
+				s, err = state.Load(id)
+				if os.IsNotExist(err) {
+					// the container has been deleted; there is nothing left
+					// to observe
+					return nil
+				} else if err != nil {
+					return err
+				}
+				next, err := currentStatus(ctx, s)
+				if err != nil {
+					return err
+				}
+				if next != status {
+					if err := enc.Encode(Event{Type: EventTypeState, ID: id, Data: StateEvent{Status: string(next)}}); err != nil {
+						return err
+					}
+					if next == state.StatusStopped {
+						if err := enc.Encode(Event{Type: EventTypeExit, ID: id, Data: ExitEvent{PID: s.PID}}); err != nil {
+							return err
+						}
+					}
+					status = next
+				}
+				if status != state.StatusRunning {
+					continue
+				}
+				st, err := jailStats(ctx, id)
+				if err != nil {
+					logrus.WithError(err).Warn("events: failed to collect stats")
+					continue
+				}
+				if err := enc.Encode(Event{Type: EventTypeStats, ID: id, Data: st}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return events
+}
+
+// currentStatus reports the status of the container, accounting for a running
+// container whose processes have all exited.  Unlike the state command, the
+// state file is not updated.
+func currentStatus(ctx context.Context, s *state.State) (state.Status, error) {
+	if s.Status != state.StatusRunning {
+		return s.Status, nil
+	}
+	backend, err := jail.GetBackend(s.Backend)
+	if err != nil {
+		return "", err
+	}
+	ok, err := backend.IsRunning(ctx, s.ID, s.PID)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return state.StatusStopped, nil
+	}
+	return s.Status, nil
+}
+
+// jailStats collects resource usage for the jail and converts it into the
+// runc-compatible Stats format.
+func jailStats(ctx context.Context, id string) (*Stats, error) {
+	usage, err := jail.Usage(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	st := &Stats{Rctl: usage}
+	// rctl(8) reports cputime in seconds
+	st.CPU.Usage.Total = usage["cputime"] * uint64(time.Second)
+	st.Memory.Usage.Usage = usage["memoryuse"]
+	st.Memory.Swap.Usage = usage["swapuse"]
+	st.Pids.Current = usage["maxproc"]
+	return st, nil
+}
+
+// rctlEvent converts an rctl(8) notification into an Event.  Memory rules are
+// reported with the "oom" type used by runc.
+func rctlEvent(id string, e jail.RctlEvent) Event {
+	typ := EventTypeRctl
+	if e.IsMemory() {
+		typ = EventTypeOOM
+	}
+	return Event{
+		Type: typ,
+		ID:   id,
+		Data: RctlEvent{
+			Rule:     e.Rule,
+			Resource: e.Resource,
+			PID:      e.PID,
+		},
+	}
+}
+
+const (
+	EventTypeStats = "stats"
+	EventTypeOOM   = "oom"
+	EventTypeRctl  = "rctl"
+	EventTypeState = "state"
+	EventTypeExit  = "exit"
+)
+
+// Event is the envelope for all events, matching runc's events format.
+type Event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// StateEvent is sent when the status of the container changes.
+type StateEvent struct {
+	Status string `json:"status"`
+}
+
+// ExitEvent is sent when the container's primary process has exited.
+type ExitEvent struct {
+	PID int `json:"pid"`
+}
+
+// RctlEvent is sent when an rctl(8) rule with the "devctl" action matches a
+// process in the container.
+type RctlEvent struct {
+	Rule     string `json:"rule"`
+	Resource string `json:"resource"`
+	PID      int    `json:"pid,omitempty"`
+}
+
+// Stats is the subset of runc's stats format that can be populated from
+// rctl(8).  The raw rctl(8) usage is included as well.
+type Stats struct {
+	CPU    CPU               `json:"cpu"`
+	Memory Memory            `json:"memory"`
+	Pids   Pids              `json:"pids"`
+	Rctl   map[string]uint64 `json:"rctl,omitempty"`
+}
+
+type CPU struct {
+	Usage CPUUsage `json:"usage,omitempty"`
+}
+
+type CPUUsage struct {
+	// Total is the total CPU time consumed, in nanoseconds
+	Total uint64 `json:"total,omitempty"`
+}
+
+type Memory struct {
+	Usage MemoryEntry `json:"usage,omitempty"`
+	Swap  MemoryEntry `json:"swap,omitempty"`
+}
+
+type MemoryEntry struct {
+	Usage uint64 `json:"usage,omitempty"`
+}
+
+type Pids struct {
+	Current uint64 `json:"current,omitempty"`
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"io/ioutil"
-	"os/exec"
 
 	"go.sbk.wtf/runj/oci"
 	"go.sbk.wtf/runj/runtimespec"
@@ -34,6 +33,7 @@ func execCommand() *cobra.Command {
 		Args:  cobra.MinimumNArgs(1),
 	}
 	processJsonFlag := execCmd.Flags().StringP("process", "p", "", "process.json")
+	preserveFDs := execCmd.Flags().Int("preserve-fds", 0, "pass N additional file descriptors to the process, starting at fd 3")
 	execCmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		if processJsonFlag == nil || *processJsonFlag == "" {
 			// 2 args are required when -p not specified
@@ -52,7 +52,11 @@ func execCommand() *cobra.Command {
 		if s.Status != state.StatusRunning {
 			return errors.New("cannot exec non-running container")
 		}
-		if ok, err := jail.IsRunning(cmd.Context(), id, s.PID); !ok {
+		backend, err := jail.GetBackend(s.Backend)
+		if err != nil {
+			return err
+		}
+		if ok, err := backend.IsRunning(cmd.Context(), id, s.PID); !ok {
 			return errors.New("cannot exec non-running container")
 		} else if err != nil {
 			return err
@@ -78,10 +82,18 @@ func execCommand() *cobra.Command {
 			process.Args = args[1:]
 		}
 
+		// validation only; the entrypoint looks the path up again in the
+		// jail
+		if s.Rootfs != "" {
+			if _, err := oci.LookPath(s.Rootfs, &process); err != nil {
+				return err
+			}
+		}
+
 		// Setup and start the "runj-entrypoint" helper program in order to
 		// get the container STDIO hooked up properly.
-		var entrypoint *exec.Cmd
-		entrypoint, err = jail.SetupEntrypoint(id, false, process.Args, process.Env, "")
+		var entrypoint *jail.Entrypoint
+		entrypoint, err = jail.SetupEntrypoint(id, s.Backend, false, process.Args, oci.ProcessEnv(&process), nil, jail.ListenFDs(), *preserveFDs)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.sbk.wtf/runj/oci"
+
+	"github.com/spf13/cobra"
+)
+
+// featuresCommand implements the "features" command, which is patterned after
+// the "features" command from runc and outputs the OCI runtime features
+// document.
+//
+// features
+//
+// The features document describes what runj supports so that callers can
+// discover it before sending a config.  The lists are generated from the same
+// tables that runj uses to translate config.json into a jail.
+func featuresCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "features",
+		Short: "Show the enabled features",
+		Long:  "Show the features supported by runj as an OCI runtime features JSON document",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			disableUsage(cmd)
+			b, err := json.MarshalIndent(oci.SupportedFeatures(), "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/oci"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runjOutput runs a runj command in-process and returns its standard output.
+func runjOutput(t *testing.T, args ...string) (string, error) {
+	out, err := ioutil.TempFile("", "runj-stdout")
+	require.NoError(t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+	saved := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = saved }()
+	cmd := newRootCommand()
+	cmd.SetArgs(args)
+	err = cmd.Execute()
+	b, readErr := ioutil.ReadFile(out.Name())
+	require.NoError(t, readErr)
+	return string(b), err
+}
+
+func TestFeatures(t *testing.T) {
+	out, err := runjOutput(t, "features")
+	require.NoError(t, err)
+	features := &oci.Features{}
+	require.NoError(t, json.Unmarshal([]byte(out), features), out)
+
+	assert.Equal(t, oci.MinimumVersion, features.OCIVersionMin)
+	assert.Equal(t, oci.MaximumVersion, features.OCIVersionMax)
+	// runj runs no hooks and supports no mounts from config.json
+	assert.Equal(t, []string{}, features.Hooks)
+	assert.Equal(t, []string{}, features.MountOptions)
+	require.NotNil(t, features.FreeBSD)
+	assert.Equal(t, jail.Parameters(), features.FreeBSD.JailParameters)
+	assert.Equal(t, jail.DefaultDevfsRuleset, features.FreeBSD.DevfsRuleset)
+	// mount.devfs is the only mount.* parameter
+	assert.Equal(t, []string{"devfs"}, features.FreeBSD.MountTypes)
+	assert.Equal(t, oci.SupportedFields(), features.FreeBSD.ConfigFields)
+
+	// every annotation that config.json validation accepts is listed
+	expected := []string{oci.ConfigModeAnnotation, oci.ConsoleRawAnnotation}
+	for _, name := range jail.AllowedParameters() {
+		expected = append(expected, jail.ParameterAnnotationPrefix+name)
+	}
+	assert.Equal(t, expected, features.FreeBSD.Annotations)
+	for _, name := range jail.AllowedParameters() {
+		values := jail.AllowedValues(name)
+		if values == nil {
+			values = []string{""}
+		}
+		for _, value := range values {
+			assert.NoError(t, jail.ValidateParameter(name, value), name)
+		}
+	}
+}
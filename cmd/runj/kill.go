@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.sbk.wtf/runj/jail"
 	"go.sbk.wtf/runj/state"
@@ -25,8 +26,10 @@ func killCommand() *cobra.Command {
 	kill := &cobra.Command{
 		Use:   "kill <container-id> [signal]",
 		Short: "Send a signal to a container",
-		Long:  "Send a signal to a container.  If the signal is not specified, SIGTERM is sent.",
-		Args:  cobra.RangeArgs(1, 2),
+		Long: `Send a signal to a container.  If the signal is not specified, SIGTERM is sent.
+With --timeout, kill waits for the container's processes to exit and sends
+SIGKILL to all of them if any are left after the timeout.`,
+		Args: cobra.RangeArgs(1, 2),
 	}
 	all := false
 	kill.Flags().BoolVarP(
@@ -35,6 +38,10 @@ func killCommand() *cobra.Command {
 		"a",
 		false,
 		"send the specified signal to all processes inside the container")
+	timeout := kill.Flags().Duration(
+		"timeout",
+		0,
+		"wait for the container's processes to exit and send SIGKILL to all of them after the timeout")
 	kill.RunE = func(cmd *cobra.Command, args []string) error {
 		disableUsage(cmd)
 		id := args[0]
@@ -50,12 +57,16 @@ func killCommand() *cobra.Command {
 		if err != nil {
 			return err
 		}
+		backend, err := jail.GetBackend(s.Backend)
+		if err != nil {
+			return err
+		}
 		if s.Status == state.StatusRunning {
-			if ok, err := jail.IsRunning(cmd.Context(), id, s.PID); err != nil {
+			if ok, err := backend.IsRunning(cmd.Context(), id, s.PID); err != nil {
 				return err
 			} else if !ok {
 				s.Status = state.StatusStopped
-				if err := s.Save(); err != nil {
+				if err := markStopped(id); err != nil {
 					return err
 				}
 			}
@@ -63,11 +74,18 @@ func killCommand() *cobra.Command {
 		if s.Status != state.StatusRunning {
 			return errors.New("cannot signal non-running container")
 		}
+		pid := s.PID
 		if all {
-			return jail.KillAll(cmd.Context(), id, signal)
-		} else {
-			return jail.Kill(cmd.Context(), id, s.PID, signal)
+			pid = -1
+		}
+		sentAt := time.Now()
+		if err := backend.Kill(cmd.Context(), id, pid, signal); err != nil {
+			return err
+		}
+		if *timeout == 0 {
+			return nil
 		}
+		return awaitExit(cmd.Context(), cmd.OutOrStdout(), backend, id, s.PID, signal, sentAt, *timeout)
 	}
 	return kill
 }
@@ -0,0 +1,666 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/jail/jailtest"
+	"go.sbk.wtf/runj/runtimespec"
+
+	runc "github.com/containerd/go-runc"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// entrypointBuilt records whether TestMain built runj-entrypoint with the fake
+// jail backend.  The lifecycle tests are skipped if it could not be built.
+var entrypointBuilt bool
+
+func TestMain(m *testing.M) {
+	// "create --monitor" runs the monitor from the test binary
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-test.") {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(testMain(m))
+}
+
+func testMain(m *testing.M) int {
+	dir, err := ioutil.TempDir("", "runj-entrypoint")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	if _, err := exec.LookPath("go"); err == nil {
+		build := exec.Command("go", "build", "-tags", "fakejail",
+			"-o", filepath.Join(dir, "runj-entrypoint"), "go.sbk.wtf/runj/cmd/runj-entrypoint")
+		if out, err := build.CombinedOutput(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to build runj-entrypoint: %v: %s\n", err, out)
+			return 1
+		}
+		os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		entrypointBuilt = true
+	}
+	return m.Run()
+}
+
+// lifecycle drives the runj commands for a single container using the fake
+// jail backend.
+type lifecycle struct {
+	t      *testing.T
+	id     string
+	root   string
+	bundle string
+	// stdio receives the output of the container when no terminal is used
+	stdio *os.File
+}
+
+func newLifecycle(t *testing.T, process *runtimespec.Process) *lifecycle {
+	if !entrypointBuilt {
+		t.Skip("go is required to build runj-entrypoint")
+	}
+	dir, err := ioutil.TempDir("", "runj-lifecycle")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	jails := filepath.Join(dir, "jails")
+	setenv(t, jailtest.RootEnv, jails)
+	l := &lifecycle{
+		t:      t,
+		id:     strings.ReplaceAll(t.Name(), "/", "-"),
+		root:   filepath.Join(dir, "state"),
+		bundle: filepath.Join(dir, "bundle"),
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(l.bundle, "root"), 0755))
+	if len(process.Args) > 0 {
+		// the fake backend runs programs from the host, but create looks for
+		// them in the root filesystem
+		path, err := exec.LookPath(process.Args[0])
+		if err == nil {
+			stub := filepath.Join(l.bundle, "root", path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(stub), 0755))
+			require.NoError(t, ioutil.WriteFile(stub, nil, 0755))
+		}
+	}
+	process.Cwd = "/"
+	process.Env = append(process.Env,
+		"PATH="+os.Getenv("PATH"),
+		jailtest.RootEnv+"="+jails,
+	)
+	config, err := json.Marshal(&runtimespec.Spec{
+		Version: runtimespec.Version,
+		Process: process,
+		Root:    &runtimespec.Root{Path: "root"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(l.bundle, "config.json"), config, 0644))
+
+	l.stdio, err = os.Create(filepath.Join(dir, "stdio"))
+	require.NoError(t, err)
+	t.Cleanup(func() { l.stdio.Close() })
+	return l
+}
+
+// setenv sets an environment variable for the duration of the test.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// runj runs a runj command in-process and returns its standard output.
+func (l *lifecycle) runj(args ...string) (string, error) {
+	out, err := ioutil.TempFile("", "runj-stdout")
+	require.NoError(l.t, err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+	err = l.runjWithStdout(out, args...)
+	b, readErr := ioutil.ReadFile(out.Name())
+	require.NoError(l.t, readErr)
+	return string(b), err
+}
+
+// runjWithStdout runs a runj command in-process with its standard output, which
+// is inherited by runj-entrypoint, redirected to stdout.
+func (l *lifecycle) runjWithStdout(stdout *os.File, args ...string) error {
+	saved := os.Stdout
+	os.Stdout = stdout
+	defer func() { os.Stdout = saved }()
+	cmd := newRootCommand()
+	cmd.SetArgs(append([]string{"--root", l.root}, args...))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return cmd.ExecuteContext(ctx)
+}
+
+// runjProcess runs runj as a separate process, which is required to pass it
+// file descriptors beyond stdio.  The test binary acts as runj.
+func (l *lifecycle) runjProcess(extraFiles []*os.File, args ...string) error {
+	cmd := exec.Command(os.Args[0], append([]string{"--root", l.root}, args...)...)
+	cmd.Stdout = l.stdio
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = extraFiles
+	return cmd.Run()
+}
+
+func (l *lifecycle) state() *StateOutput {
+	out, err := l.runj("state", l.id)
+	require.NoError(l.t, err, "state")
+	s := &StateOutput{}
+	require.NoError(l.t, json.Unmarshal([]byte(out), s), out)
+	return s
+}
+
+// wait reaps the container process, which is a child of the test because
+// runj-entrypoint is started in-process by "create".
+func (l *lifecycle) wait(pid int) unix.WaitStatus {
+	var ws unix.WaitStatus
+	_, err := unix.Wait4(pid, &ws, 0, nil)
+	require.NoError(l.t, err, "wait4")
+	return ws
+}
+
+// eventually polls until cond returns true or the timeout expires.
+func eventually(t *testing.T, cond func() bool, msg string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "echo hello; exec sleep 60"},
+	})
+
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, l.id, l.bundle), "create")
+	created := l.state()
+	assert.Equal(t, "created", created.Status)
+	assert.NotZero(t, created.PID)
+	assert.NotZero(t, created.JID)
+	config, err := jailtest.Config(l.id)
+	require.NoError(t, err, "jail should exist")
+	rootfs, err := filepath.EvalSymlinks(filepath.Join(l.bundle, "root"))
+	require.NoError(t, err)
+	assert.Equal(t, rootfs, config.Root)
+	assert.Equal(t, rootfs, created.Rootfs)
+
+	list, err := l.runj("list", "--quiet")
+	require.NoError(t, err, "list")
+	assert.Equal(t, l.id+"\n", list)
+
+	// the process must not run until start completes the exec fifo handshake
+	output, err := ioutil.ReadFile(l.stdio.Name())
+	require.NoError(t, err)
+	assert.Empty(t, string(output))
+
+	_, err = l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	assert.Equal(t, "running", l.state().Status)
+	eventually(t, func() bool {
+		output, _ := ioutil.ReadFile(l.stdio.Name())
+		return bytes.Contains(output, []byte("hello"))
+	}, "container output not received")
+
+	_, err = l.runj("start", l.id)
+	assert.Error(t, err, "starting a running container")
+
+	_, err = l.runj("delete", l.id)
+	assert.Error(t, err, "deleting a running container")
+
+	_, err = l.runj("kill", l.id, "KILL")
+	require.NoError(t, err, "kill")
+	ws := l.wait(created.PID)
+	assert.True(t, ws.Signaled())
+	assert.Equal(t, unix.SIGKILL, ws.Signal())
+	stopped := l.state()
+	assert.Equal(t, "stopped", stopped.Status)
+	assert.Zero(t, stopped.PID)
+
+	_, err = l.runj("kill", l.id, "KILL")
+	assert.Error(t, err, "killing a stopped container")
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+	_, err = l.runj("state", l.id)
+	assert.Error(t, err, "state after delete")
+	_, err = jailtest.Config(l.id)
+	assert.Error(t, err, "jail should be destroyed")
+}
+
+func TestLifecycleConsole(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Terminal: true,
+		Args:     []string{"sh", "-c", "tty && echo hello"},
+	})
+	socket, err := runc.NewTempConsoleSocket()
+	require.NoError(t, err)
+	defer socket.Close()
+
+	_, err = l.runj("create", "--jail-backend", jailtest.Name, "--console-socket", socket.Path(), l.id, l.bundle)
+	require.NoError(t, err, "create")
+	master, err := socket.ReceiveMaster()
+	require.NoError(t, err, "receive console master")
+	defer master.Close()
+	pid := l.state().PID
+
+	output := make(chan string)
+	go func() {
+		// reading fails once the process exits and the slave is closed
+		b, _ := ioutil.ReadAll(master)
+		output <- string(b)
+	}()
+
+	_, err = l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	ws := l.wait(pid)
+	assert.True(t, ws.Exited())
+	assert.Equal(t, 0, ws.ExitStatus())
+	select {
+	case out := <-output:
+		assert.Contains(t, out, "/dev/")
+		assert.Contains(t, out, "hello")
+	case <-time.After(5 * time.Second):
+		t.Fatal("console output not received")
+	}
+
+	assert.Equal(t, "stopped", l.state().Status)
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestLifecycleMonitor(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "echo hello; exit 3"},
+	})
+
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, "--monitor", l.id, l.bundle), "create")
+	created := l.state()
+	assert.Equal(t, "created", created.Status)
+	assert.NotZero(t, created.PID)
+
+	_, err := l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	status, err := l.runj("wait", l.id)
+	require.NoError(t, err, "wait")
+	assert.Equal(t, "3\n", status)
+	output, err := ioutil.ReadFile(l.stdio.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(output))
+
+	stopped := l.state()
+	assert.Equal(t, "stopped", stopped.Status)
+	require.NotNil(t, stopped.ExitStatus)
+	assert.Equal(t, 3, *stopped.ExitStatus)
+	assert.NotNil(t, stopped.ExitedAt)
+
+	// waiting again returns the recorded status immediately
+	status, err = l.runj("wait", l.id)
+	require.NoError(t, err, "wait")
+	assert.Equal(t, "3\n", status)
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestLifecycleContainerLog(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "echo out; echo err >&2; exit 1"},
+	})
+	logPath := filepath.Join(filepath.Dir(l.bundle), "container.log")
+
+	// --debug is passed on to the monitor, whose messages must not end up in
+	// the container log
+	t.Cleanup(func() { logrus.SetLevel(logrus.InfoLevel) })
+	require.NoError(t, l.runjWithStdout(l.stdio, "--debug", "create", "--jail-backend", jailtest.Name,
+		"--container-log", logPath, "--container-log-format", "json", l.id, l.bundle), "create")
+	_, err := l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	status, err := l.runj("wait", l.id)
+	require.NoError(t, err, "wait")
+	assert.Equal(t, "1\n", status)
+
+	output, err := ioutil.ReadFile(l.stdio.Name())
+	require.NoError(t, err)
+	assert.Empty(t, string(output), "output should only be written to the log")
+	data, err := ioutil.ReadFile(logPath)
+	require.NoError(t, err)
+	streams := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		entry := struct {
+			Log    string    `json:"log"`
+			Stream string    `json:"stream"`
+			Time   time.Time `json:"time"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		assert.False(t, entry.Time.IsZero())
+		streams[entry.Stream] += entry.Log
+	}
+	assert.Equal(t, map[string]string{"stdout": "out\n", "stderr": "err\n"}, streams)
+	monitorLog, err := ioutil.ReadFile(filepath.Join(l.root, l.id, monitorLogFilename))
+	require.NoError(t, err)
+	assert.Contains(t, string(monitorLog), "container exited")
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestWaitWithoutMonitor(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "exit 0"},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, l.id, l.bundle), "create")
+	pid := l.state().PID
+	_, err := l.runj("wait", l.id)
+	assert.Error(t, err)
+
+	_, err = l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	l.wait(pid)
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestCreatePreserveFDs(t *testing.T) {
+	for _, monitor := range []bool{false, true} {
+		t.Run(fmt.Sprintf("monitor=%t", monitor), func(t *testing.T) {
+			l := newLifecycle(t, &runtimespec.Process{
+				Args: []string{"sh", "-c", `echo "$LISTEN_FDS $LISTEN_PID $$" >&3`},
+				Env:  []string{"LISTEN_FDS=1"},
+			})
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+			defer r.Close()
+			args := []string{"create", "--jail-backend", jailtest.Name, "--preserve-fds", "1"}
+			if monitor {
+				args = append(args, "--monitor")
+			}
+			err = l.runjProcess([]*os.File{w}, append(args, l.id, l.bundle)...)
+			w.Close()
+			require.NoError(t, err, "create")
+
+			_, err = l.runj("start", l.id)
+			require.NoError(t, err, "start")
+			// the pipe is closed when the container process exits
+			output, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			fields := strings.Fields(string(output))
+			require.Len(t, fields, 3, string(output))
+			assert.Equal(t, "1", fields[0])
+			assert.Equal(t, fields[2], fields[1], "LISTEN_PID should be the container process")
+
+			eventually(t, func() bool { return l.state().Status == "stopped" }, "container did not stop")
+			_, err = l.runj("delete", l.id)
+			require.NoError(t, err, "delete")
+		})
+	}
+}
+
+func TestCreateExecutableNotFound(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"does-not-exist"},
+	})
+	_, err := l.runj("create", "--jail-backend", jailtest.Name, l.id, l.bundle)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "executable not found")
+	_, err = jailtest.Config(l.id)
+	assert.Error(t, err, "jail should not be created")
+}
+
+func TestCreateEntrypointFailure(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"true"},
+	})
+	// the jail is created before runj-entrypoint is started
+	setenv(t, "PATH", "")
+	_, err := l.runj("create", "--jail-backend", jailtest.Name, l.id, l.bundle)
+	require.Error(t, err)
+	_, err = jailtest.Config(l.id)
+	assert.Error(t, err, "jail should be destroyed")
+	_, err = l.runj("state", l.id)
+	assert.Error(t, err, "state should be removed")
+}
+
+func TestStartExecFailure(t *testing.T) {
+	const name = "runj-not-on-host"
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{name},
+	})
+	// create finds the program in the root filesystem, but the fake backend
+	// looks for it on the host
+	dir := filepath.SplitList(os.Getenv("PATH"))[0]
+	stub := filepath.Join(l.bundle, "root", dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(stub), 0755))
+	require.NoError(t, ioutil.WriteFile(stub, nil, 0755))
+
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, l.id, l.bundle), "create")
+	pid := l.state().PID
+	_, err := l.runj("start", l.id)
+	require.Error(t, err, "start")
+	entrypointErr := &jail.EntrypointError{}
+	require.True(t, errors.As(err, &entrypointErr), "error should be an EntrypointError: %v", err)
+	assert.Equal(t, jail.EntrypointErrorNotFound, entrypointErr.Kind)
+	assert.Contains(t, entrypointErr.Message, name)
+	ws := l.wait(pid)
+	assert.Equal(t, 6, ws.ExitStatus())
+	assert.Equal(t, "stopped", l.state().Status)
+
+	_, err = l.runj("start", l.id)
+	assert.Error(t, err, "starting a stopped container")
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestStartEntrypointExited(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "exit 0"},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, l.id, l.bundle), "create")
+	pid := l.state().PID
+	require.NoError(t, unix.Kill(pid, unix.SIGKILL))
+	l.wait(pid)
+
+	_, err := l.runj("start", l.id)
+	require.Error(t, err, "start")
+	assert.True(t, errors.Is(err, jail.ErrEntrypointExited), err.Error())
+	assert.Equal(t, "stopped", l.state().Status)
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestStartTimeout(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "exit 0"},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, l.id, l.bundle), "create")
+	pid := l.state().PID
+	// a stopped runj-entrypoint never completes the exec fifo handshake
+	require.NoError(t, unix.Kill(pid, unix.SIGSTOP))
+	defer func() {
+		unix.Kill(pid, unix.SIGKILL)
+		l.wait(pid)
+	}()
+
+	_, err := l.runj("start", "--timeout", "200ms", l.id)
+	require.Error(t, err, "start")
+	assert.Contains(t, err.Error(), "timed out")
+	assert.Equal(t, "created", l.state().Status)
+}
+
+func TestLifecycleConsoleSize(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Terminal:    true,
+		ConsoleSize: &runtimespec.Box{Height: 24, Width: 100},
+		Args:        []string{"sh", "-c", "stty size; read line; stty size"},
+	})
+	socket, err := runc.NewTempConsoleSocket()
+	require.NoError(t, err)
+	defer socket.Close()
+
+	_, err = l.runj("create", "--jail-backend", jailtest.Name, "--console-socket", socket.Path(), l.id, l.bundle)
+	require.NoError(t, err, "create")
+	master, err := socket.ReceiveMaster()
+	require.NoError(t, err, "receive console master")
+	defer master.Close()
+	created := l.state()
+	assert.NotEmpty(t, created.ConsolePath)
+
+	var (
+		mu     sync.Mutex
+		output bytes.Buffer
+	)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := master.Read(buf)
+			mu.Lock()
+			output.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	contains := func(s string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return strings.Contains(output.String(), s)
+		}
+	}
+
+	_, err = l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	eventually(t, contains("24 100"), "initial console size not applied")
+
+	_, err = l.runj("resize", l.id, "120", "40")
+	require.NoError(t, err, "resize")
+	_, err = master.Write([]byte("\n"))
+	require.NoError(t, err)
+	eventually(t, contains("40 120"), "console not resized")
+	l.wait(created.PID)
+
+	_, err = l.runj("resize", l.id, "80", "24")
+	assert.Error(t, err, "resizing a stopped container")
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestCreateInvalidConfig(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{})
+	_, err := l.runj("create", "--jail-backend", jailtest.Name, l.id, l.bundle)
+	assert.Error(t, err)
+	_, err = jailtest.Config(l.id)
+	assert.Error(t, err, "jail should not be created")
+	_, err = os.Stat(filepath.Join(l.root, l.id))
+	assert.True(t, os.IsNotExist(err), "state should not be created")
+}
+
+func TestStop(t *testing.T) {
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sleep", "60"},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, "--monitor", l.id, l.bundle), "create")
+	_, err := l.runj("stop", l.id)
+	assert.Error(t, err, "stopping a created container")
+	_, err = l.runj("start", l.id)
+	require.NoError(t, err, "start")
+
+	out, err := l.runj("stop", "--timeout", "5s", l.id)
+	require.NoError(t, err, "stop")
+	assert.Equal(t, "container "+l.id+" exited with status 143 after SIGTERM\n", out)
+	status, err := l.runj("wait", l.id)
+	require.NoError(t, err, "wait")
+	assert.Equal(t, "143\n", status)
+
+	out, err = l.runj("stop", l.id)
+	require.NoError(t, err, "stopping a stopped container")
+	assert.Equal(t, "container "+l.id+" is already stopped\n", out)
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+// readyFile returns the path of a file for the container process to create
+// once it is ready to receive a signal, and a function that waits for it.
+func readyFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "runj-ready")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ready")
+	return path, func() {
+		eventually(t, func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}, "container process is not ready")
+	}
+}
+
+func TestStopHandledSignal(t *testing.T) {
+	ready, waitReady := readyFile(t)
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "trap 'exit 3' TERM; touch \"$READY\"; while :; do sleep 0.1; done"},
+		Env:  []string{"READY=" + ready},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, "--monitor", l.id, l.bundle), "create")
+	_, err := l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	waitReady()
+
+	out, err := l.runj("stop", "--timeout", "5s", l.id)
+	require.NoError(t, err, "stop")
+	assert.Equal(t, "container "+l.id+" exited with status 3 after SIGTERM\n", out)
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
+
+func TestKillTimeout(t *testing.T) {
+	// the signal is ignored, so kill escalates to SIGKILL
+	ready, waitReady := readyFile(t)
+	l := newLifecycle(t, &runtimespec.Process{
+		Args: []string{"sh", "-c", "trap '' TERM; touch \"$READY\"; exec sleep 60"},
+		Env:  []string{"READY=" + ready},
+	})
+	require.NoError(t, l.runjWithStdout(l.stdio, "create", "--jail-backend", jailtest.Name, "--monitor", l.id, l.bundle), "create")
+	_, err := l.runj("start", l.id)
+	require.NoError(t, err, "start")
+	// SIGTERM would not be ignored before the trap is set
+	waitReady()
+
+	out, err := l.runj("kill", "--timeout", "300ms", l.id, "TERM")
+	require.NoError(t, err, "kill")
+	assert.Equal(t, ""+
+		"container "+l.id+" did not exit within 300ms after SIGTERM; sending SIGKILL to all processes\n"+
+		"container "+l.id+" exited with status 137 after SIGKILL\n", out)
+	status, err := l.runj("wait", l.id)
+	require.NoError(t, err, "wait")
+	assert.Equal(t, "137\n", status)
+
+	_, err = l.runj("delete", l.id)
+	require.NoError(t, err, "delete")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.sbk.wtf/runj/state"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// statusUnknown is reported for a container whose state cannot be read or
+// whose status cannot be determined
+const statusUnknown state.Status = "unknown"
+
+// listCommand lists the containers with state in the root directory
+func listCommand() *cobra.Command {
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List containers",
+		Args:  cobra.NoArgs,
+	}
+	format := list.Flags().StringP("format", "f", "table", `output format ("table" or "json")`)
+	quiet := list.Flags().BoolP("quiet", "q", false, "display only container IDs")
+	list.RunE = func(cmd *cobra.Command, args []string) error {
+		if *format != "table" && *format != "json" {
+			return fmt.Errorf("invalid format %q", *format)
+		}
+		disableUsage(cmd)
+		ids, err := state.List()
+		if err != nil {
+			return err
+		}
+		var outputs []*StateOutput
+		for _, id := range ids {
+			s, err := state.Load(id)
+			if os.IsNotExist(err) {
+				// the container may have been deleted since it was listed
+				continue
+			}
+			if err != nil {
+				logrus.WithError(err).WithField("id", id).Warn("list: failed to load state")
+				outputs = append(outputs, &StateOutput{ID: id, Status: string(statusUnknown)})
+				continue
+			}
+			status, err := currentStatus(cmd.Context(), s)
+			if err != nil {
+				logrus.WithError(err).WithField("id", id).Warn("list: failed to determine status")
+				status = statusUnknown
+			}
+			s.Status = status
+			if s.Status == state.StatusStopped {
+				s.PID = 0
+			}
+			outputs = append(outputs, stateOutput(s))
+		}
+		if *quiet {
+			for _, o := range outputs {
+				fmt.Println(o.ID)
+			}
+			return nil
+		}
+		if *format == "json" {
+			if outputs == nil {
+				outputs = []*StateOutput{}
+			}
+			b, err := json.MarshalIndent(outputs, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+		fmt.Fprint(w, "ID\tPID\tJID\tSTATUS\tBUNDLE\tCREATED\tOWNER\n")
+		for _, o := range outputs {
+			created := ""
+			if o.Created != nil {
+				created = o.Created.Format(time.RFC3339Nano)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\n", o.ID, o.PID, o.JID, o.Status, o.Bundle, created, o.Owner)
+		}
+		return w.Flush()
+	}
+	return list
+}
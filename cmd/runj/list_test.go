@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.sbk.wtf/runj/state"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listRoot returns a state root with a created container, a stopped
+// container, a running container whose backend does not exist, and a
+// container with an unreadable state file.
+func listRoot(t *testing.T) string {
+	root, err := ioutil.TempDir("", "runj-list")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+		state.SetRoot(state.DefaultRoot)
+	})
+	for id, contents := range map[string]string{
+		"created": `{"id":"created","jid":3,"status":"created","bundle":"/bundles/created","pid":10,"created":"2021-04-01T12:00:00Z","owner":"root"}`,
+		"stopped": `{"id":"stopped","jid":4,"status":"stopped","bundle":"/bundles/stopped","pid":11,"created":"2021-04-01T12:00:00Z","owner":"root"}`,
+		"lost":    `{"id":"lost","jid":5,"status":"running","bundle":"/bundles/lost","pid":12,"backend":"missing","owner":"root"}`,
+		"corrupt": `{"id":`,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, id), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(root, id, "state.json"), []byte(contents), 0644))
+	}
+	return root
+}
+
+func TestListTable(t *testing.T) {
+	root := listRoot(t)
+	out, err := runjOutput(t, "--root", root, "list")
+	require.NoError(t, err)
+
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+	assert.Equal(t, [][]string{
+		{"ID", "PID", "JID", "STATUS", "BUNDLE", "CREATED", "OWNER"},
+		{"corrupt", "0", "0", "unknown"},
+		{"created", "10", "3", "created", "/bundles/created", "2021-04-01T12:00:00Z", "root"},
+		{"lost", "12", "5", "unknown", "/bundles/lost", "root"},
+		{"stopped", "0", "4", "stopped", "/bundles/stopped", "2021-04-01T12:00:00Z", "root"},
+	}, rows)
+}
+
+func TestListJSON(t *testing.T) {
+	root := listRoot(t)
+	out, err := runjOutput(t, "--root", root, "list", "--format", "json")
+	require.NoError(t, err)
+
+	var outputs []*StateOutput
+	require.NoError(t, json.Unmarshal([]byte(out), &outputs), out)
+	statuses := make(map[string]string)
+	for _, o := range outputs {
+		statuses[o.ID] = o.Status
+	}
+	assert.Equal(t, map[string]string{
+		"corrupt": "unknown",
+		"created": "created",
+		"lost":    "unknown",
+		"stopped": "stopped",
+	}, statuses)
+	for _, o := range outputs {
+		if o.ID == "created" {
+			assert.Equal(t, 10, o.PID)
+			assert.Equal(t, 3, o.JID)
+			assert.Equal(t, "/bundles/created", o.Bundle)
+		}
+	}
+}
+
+func TestListEmpty(t *testing.T) {
+	root, err := ioutil.TempDir("", "runj-list")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	defer state.SetRoot(state.DefaultRoot)
+
+	out, err := runjOutput(t, "--root", root, "list", "--format", "json")
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", out)
+}
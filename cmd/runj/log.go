@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configureLogging sets up logrus for runj's own diagnostic output.  When path
+// is empty, logs are written to stderr.
+func configureLogging(path, format string, debug bool) error {
+	if debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	switch format {
+	case "text":
+		// logrus default
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log-format %q", format)
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_SYNC, 0644)
+		if err != nil {
+			return err
+		}
+		logrus.SetOutput(f)
+	}
+	return nil
+}
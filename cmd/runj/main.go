@@ -3,25 +3,54 @@ package main
 import (
 	"os"
 
+	"go.sbk.wtf/runj/state"
+
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 func main() {
+	rootCmd := newRootCommand()
+	err := rootCmd.Execute()
+	if err != nil {
+		// cobra has already printed the error to stderr; record it in the log
+		// file too so that callers can retrieve it from there
+		if logPath, _ := rootCmd.PersistentFlags().GetString("log"); logPath != "" {
+			logrus.Error(err)
+		}
+		os.Exit(1)
+	}
+}
+
+// newRootCommand constructs the runj command and its subcommands
+func newRootCommand() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "runj <command>",
 		Short: "runj is a skeleton OCI runtime for FreeBSD",
 	}
+	root := rootCmd.PersistentFlags().String("root", state.DefaultRoot, "root directory for storage of jail state")
+	logPath := rootCmd.PersistentFlags().String("log", "", "set the log file path where runj's logs are written (default is stderr)")
+	logFormat := rootCmd.PersistentFlags().String("log-format", "text", "set the format used by logs ('text' or 'json')")
+	debug := rootCmd.PersistentFlags().Bool("debug", false, "enable debug output for logging")
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		state.SetRoot(*root)
+		return configureLogging(*logPath, *logFormat, *debug)
+	}
 	rootCmd.AddCommand(stateCommand())
 	rootCmd.AddCommand(createCommand())
 	rootCmd.AddCommand(startCommand())
 	rootCmd.AddCommand(killCommand())
+	rootCmd.AddCommand(stopCommand())
 	rootCmd.AddCommand(deleteCommand())
+	rootCmd.AddCommand(waitCommand())
+	rootCmd.AddCommand(resizeCommand())
+	rootCmd.AddCommand(monitorCommand())
+	rootCmd.AddCommand(listCommand())
+	rootCmd.AddCommand(eventsCommand())
+	rootCmd.AddCommand(featuresCommand())
 	rootCmd.AddCommand(extCommand())
 	rootCmd.AddCommand(demoCommand())
-	err := rootCmd.Execute()
-	if err != nil {
-		os.Exit(1)
-	}
+	return rootCmd
 }
 
 // disableUsage is a helper to disable the Usage output on errors.  This helper
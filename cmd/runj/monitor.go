@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.sbk.wtf/runj/containerlog"
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/oci"
+	"go.sbk.wtf/runj/procctl"
+	"go.sbk.wtf/runj/state"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// monitorLockFilename is locked by the monitor for as long as it runs so
+	// that `runj wait` can block until the container's exit is recorded
+	monitorLockFilename = "monitor.lock"
+	// monitorLogFilename receives the monitor's own log messages when runj is
+	// not given a --log file, since the monitor's stderr is replaced
+	monitorLogFilename = "monitor.log"
+	// monitorStatusFd is the file descriptor the monitor uses to report the
+	// container's pid to `runj create`, following any preserved file
+	// descriptors
+	monitorStatusFd = 3
+	// logDrainTimeout is how long the monitor waits for the container's output
+	// to be logged after the init process exits.  Other processes in the jail
+	// may hold the output pipes open.
+	logDrainTimeout = 2 * time.Second
+)
+
+// monitorOptions configure the monitor.  They are passed from `runj create` to
+// the monitor as flags.
+type monitorOptions struct {
+	backend       string
+	consoleSocket string
+	listenFDs     int
+	preserveFDs   int
+	// log describes the container log; output is not logged if log.Path is
+	// empty
+	log containerlog.Config
+}
+
+// args returns the flags that pass o to the monitor command.
+func (o *monitorOptions) args() []string {
+	args := []string{"--jail-backend", o.backend}
+	if o.consoleSocket != "" {
+		args = append(args, "--console-socket", o.consoleSocket)
+	}
+	if o.listenFDs > 0 {
+		args = append(args, "--listen-fds", strconv.Itoa(o.listenFDs))
+	}
+	if o.preserveFDs > 0 {
+		args = append(args, "--preserve-fds", strconv.Itoa(o.preserveFDs))
+	}
+	if o.log.Path != "" {
+		args = append(args,
+			"--container-log", o.log.Path,
+			"--container-log-format", string(o.log.Format),
+			"--container-log-max-size", strconv.FormatInt(o.log.MaxSize, 10),
+			"--container-log-max-files", strconv.Itoa(o.log.MaxFiles),
+		)
+	}
+	return args
+}
+
+// monitorStatus is sent by the monitor to `runj create` once the entrypoint has
+// been started, or when starting it failed.
+type monitorStatus struct {
+	PID         int    `json:"pid,omitempty"`
+	ConsolePath string `json:"consolePath,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// monitorCommand implements the hidden "monitor" command started by
+// `runj create --monitor`.
+//
+// The monitor becomes the reaper for its descendants, starts runj-entrypoint
+// for the container's init process, and waits for it to exit.  When a
+// container log is configured, the container's stdout and stderr are pipes to
+// the monitor, which writes them to the log.  The exit status
+// and time are recorded in the container's state, which is otherwise only
+// possible under the containerd shim.  The monitor runs in its own session so
+// that it outlives `runj create`.
+func monitorCommand() *cobra.Command {
+	monitor := &cobra.Command{
+		Use:    "monitor <container-id>",
+		Short:  "Monitor a container's init process (internal)",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+	}
+	opts := &monitorOptions{}
+	flags := monitor.Flags()
+	flags.StringVar(&opts.backend, "jail-backend", jail.DefaultBackend, "jail backend used to enter the jail")
+	flags.StringVar(&opts.consoleSocket, "console-socket", "", "path to the console socket")
+	flags.IntVar(&opts.listenFDs, "listen-fds", 0, "number of socket activation file descriptors to pass to the container")
+	flags.IntVar(&opts.preserveFDs, "preserve-fds", 0, "number of additional file descriptors to pass to the container")
+	flags.StringVar(&opts.log.Path, "container-log", "", "path of the container log")
+	logFormat := flags.String("container-log-format", string(containerlog.FormatCRI), "format of the container log")
+	flags.Int64Var(&opts.log.MaxSize, "container-log-max-size", 0, "size in bytes at which the container log is rotated")
+	flags.IntVar(&opts.log.MaxFiles, "container-log-max-files", 1, "number of container log files kept")
+	monitor.RunE = func(cmd *cobra.Command, args []string) error {
+		disableUsage(cmd)
+		id := args[0]
+		opts.log.Format = containerlog.Format(*logFormat)
+		status := os.NewFile(uintptr(monitorStatusFd+opts.listenFDs+opts.preserveFDs), "monitor-status")
+		pid, logs, err := runMonitor(id, opts, status)
+		if err != nil {
+			if status != nil {
+				json.NewEncoder(status).Encode(&monitorStatus{Error: err.Error()})
+				status.Close()
+			}
+			return err
+		}
+		// the monitor's stderr is no longer seen by anyone, so errors are
+		// logged as well as returned
+		ws, err := reap(pid)
+		if err != nil {
+			err = fmt.Errorf("monitor: failed to wait for pid %d: %w", pid, err)
+			logrus.WithField("id", id).Error(err)
+			return err
+		}
+		code := exitCode(ws)
+		logrus.WithField("id", id).WithField("pid", pid).WithField("status", code).Debug("container exited")
+		if logs != nil {
+			logs.drain(logDrainTimeout)
+		}
+		if err := state.RecordExit(id, code, time.Now()); err != nil {
+			logrus.WithField("id", id).WithError(err).Error("monitor: failed to record exit")
+			return err
+		}
+		return nil
+	}
+	return monitor
+}
+
+// runMonitor prepares the monitor and starts runj-entrypoint, reporting its pid
+// on status.  The returned containerLogs are nil unless a container log is
+// configured.
+func runMonitor(id string, opts *monitorOptions, status *os.File) (int, *containerLogs, error) {
+	if status != nil {
+		// keep the status pipe from leaking into the container, which would
+		// block `runj create` until the container exits
+		unix.CloseOnExec(int(status.Fd()))
+	}
+	if logrus.StandardLogger().Out == os.Stderr {
+		// stderr is replaced with the container log or /dev/null below
+		f, err := os.OpenFile(filepath.Join(state.Dir(id), monitorLogFilename), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return 0, nil, err
+		}
+		logrus.SetOutput(f)
+	}
+	if err := procctl.ReapAcquire(); err != nil {
+		// descendants that outlive the init process are not reaped
+		logrus.WithError(err).Warn("monitor: failed to become reaper")
+	}
+	lock, err := os.OpenFile(monitorLockPath(id), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return 0, nil, err
+	}
+	// the lock is held until the monitor exits
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return 0, nil, fmt.Errorf("monitor: container %s is already monitored: %w", id, err)
+	}
+	ociConfig, err := oci.LoadConfig(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	var logs *containerLogs
+	if opts.log.Path != "" {
+		// runj-entrypoint inherits the monitor's stdio
+		logs, err = redirectToLog(opts.log)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	console := consoleOptions(ociConfig, opts.consoleSocket)
+	entrypoint, err := jail.SetupEntrypoint(id, opts.backend, true, ociConfig.Process.Args, oci.ProcessEnv(ociConfig.Process), console, opts.listenFDs, opts.preserveFDs)
+	if err != nil {
+		return 0, nil, err
+	}
+	pid := entrypoint.Process.Pid
+	if status != nil {
+		if err := json.NewEncoder(status).Encode(&monitorStatus{PID: pid, ConsolePath: entrypoint.ConsolePath}); err != nil {
+			return 0, nil, err
+		}
+		status.Close()
+	}
+	// the container now holds the caller's stdio or the log pipes; release
+	// the monitor's copies so that the reader sees EOF when the container
+	// exits
+	if err := detachStdio(); err != nil {
+		logrus.WithError(err).Warn("monitor: failed to detach stdio")
+	}
+	return pid, logs, nil
+}
+
+// containerLogs copies the container's output to the container log.
+type containerLogs struct {
+	logger *containerlog.Logger
+	done   chan struct{}
+}
+
+// redirectToLog replaces the monitor's stdout and stderr with pipes that are
+// copied to the container log, and its stdin with /dev/null.
+func redirectToLog(c containerlog.Config) (*containerLogs, error) {
+	logger, err := containerlog.Open(c)
+	if err != nil {
+		return nil, err
+	}
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		logger.Close()
+		return nil, err
+	}
+	defer null.Close()
+	if err := unix.Dup2(int(null.Fd()), 0); err != nil {
+		logger.Close()
+		return nil, err
+	}
+	logs := &containerLogs{logger: logger, done: make(chan struct{})}
+	var copies sync.WaitGroup
+	for _, stream := range []struct {
+		name string
+		fd   int
+	}{{"stdout", 1}, {"stderr", 2}} {
+		r, w, err := os.Pipe()
+		if err != nil {
+			logger.Close()
+			return nil, err
+		}
+		err = unix.Dup2(int(w.Fd()), stream.fd)
+		w.Close()
+		if err != nil {
+			r.Close()
+			logger.Close()
+			return nil, err
+		}
+		copies.Add(1)
+		go func(name string, r *os.File) {
+			defer copies.Done()
+			defer r.Close()
+			if err := logger.Copy(name, r); err != nil {
+				logrus.WithError(err).WithField("stream", name).Warn("monitor: failed to write container log")
+			}
+		}(stream.name, r)
+	}
+	go func() {
+		copies.Wait()
+		close(logs.done)
+	}()
+	return logs, nil
+}
+
+// drain waits up to timeout for the container's output to be written to the
+// log and closes the log.
+func (l *containerLogs) drain(timeout time.Duration) {
+	select {
+	case <-l.done:
+	case <-time.After(timeout):
+		logrus.Warn("monitor: timed out writing container output to the log")
+	}
+	l.logger.Close()
+}
+
+// startMonitor starts `runj monitor` for the container and returns the status
+// it reports with the pid of the container's init process.  The file
+// descriptors to be passed to the container are passed to the monitor at the
+// same numbers.
+func startMonitor(cmd *cobra.Command, id string, opts *monitorOptions) (*monitorStatus, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	preserved, err := jail.PreservedFiles(opts.listenFDs + opts.preserveFDs)
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	args := append(globalArgs(cmd), "monitor", id)
+	monitor := exec.Command(self, append(args, opts.args()...)...)
+	if opts.log.Path == "" {
+		monitor.Stdin = os.Stdin
+		monitor.Stdout = os.Stdout
+	}
+	monitor.Stderr = os.Stderr
+	monitor.ExtraFiles = append(preserved, w)
+	monitor.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = monitor.Start()
+	for _, f := range monitor.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start monitor: %w", err)
+	}
+	defer monitor.Process.Release()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("monitor exited before starting the container")
+	}
+	status := &monitorStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("invalid monitor status %q: %w", string(data), err)
+	}
+	if status.Error != "" {
+		return nil, errors.New(status.Error)
+	}
+	return status, nil
+}
+
+// globalArgs returns the global flags for invoking runj again with the same
+// root directory and logging configuration.
+func globalArgs(cmd *cobra.Command) []string {
+	args := []string{"--root", state.Root()}
+	if path, _ := cmd.Flags().GetString("log"); path != "" {
+		args = append(args, "--log", path)
+	}
+	if format, _ := cmd.Flags().GetString("log-format"); format != "" {
+		args = append(args, "--log-format", format)
+	}
+	if debug, _ := cmd.Flags().GetBool("debug"); debug {
+		args = append(args, "--debug")
+	}
+	return args
+}
+
+// reap waits for pid to exit, reaping any other descendants that were
+// reparented to the monitor in the meantime.
+func reap(pid int) (unix.WaitStatus, error) {
+	for {
+		var ws unix.WaitStatus
+		wpid, err := unix.Wait4(-1, &ws, 0, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return ws, err
+		}
+		if wpid == pid {
+			return ws, nil
+		}
+	}
+}
+
+// exitCode converts a wait status to an exit status, using 128+signal for
+// processes killed by a signal as shells and containerd do.
+func exitCode(ws unix.WaitStatus) int {
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return ws.ExitStatus()
+}
+
+// detachStdio replaces the monitor's stdio with /dev/null.
+func detachStdio() error {
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+	for _, fd := range []int{0, 1, 2} {
+		if err := unix.Dup2(int(null.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func monitorLockPath(id string) string {
+	return filepath.Join(state.Dir(id), monitorLockFilename)
+}
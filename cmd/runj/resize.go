@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"go.sbk.wtf/runj/state"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// resizeCommand implements the "resize" command, which sets the window size of
+// a container's console for callers that do not hold the pseudoterminal
+// master.
+//
+// resize <container-id> <cols> <rows>
+func resizeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resize <container-id> <cols> <rows>",
+		Short: "Resize a container's console",
+		Long: `Resize a container's console.  The container must have been created with
+process.terminal set.  The container process receives SIGWINCH.`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id := args[0]
+			cols, err := strconv.ParseUint(args[1], 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid cols %q: %w", args[1], err)
+			}
+			rows, err := strconv.ParseUint(args[2], 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid rows %q: %w", args[2], err)
+			}
+			disableUsage(cmd)
+			s, err := state.Load(id)
+			if err != nil {
+				return err
+			}
+			status, err := currentStatus(cmd.Context(), s)
+			if err != nil {
+				return err
+			}
+			if status == state.StatusStopped {
+				return errors.New("cannot resize the console of a stopped container")
+			}
+			if s.ConsolePath == "" {
+				return fmt.Errorf("container %s does not have a console", id)
+			}
+			// the window size is shared by both ends of the pseudoterminal,
+			// and the kernel signals the foreground process group when it
+			// changes
+			fd, err := unix.Open(s.ConsolePath, unix.O_RDWR|unix.O_NOCTTY, 0)
+			if err != nil {
+				return fmt.Errorf("failed to open console %s: %w", s.ConsolePath, err)
+			}
+			defer unix.Close(fd)
+			return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{
+				Row: uint16(rows),
+				Col: uint16(cols),
+			})
+		},
+	}
+}
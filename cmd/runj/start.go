@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 
 	"go.sbk.wtf/runj/jail"
 	"go.sbk.wtf/runj/oci"
 	"go.sbk.wtf/runj/state"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -23,38 +25,68 @@ import (
 // runc's implementation of the start command exits immediately after starting
 // the container's process.  This does not appear to be specified in the spec.
 func startCommand() *cobra.Command {
-	return &cobra.Command{
+	start := &cobra.Command{
 		Use:   "start <container-id>",
 		Short: "Start a jail",
 		Long:  "The start command executes the user-defined process in a created jail",
 		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			disableUsage(cmd)
-			id := args[0]
-			ociConfig, err := oci.LoadConfig(id)
-			if err != nil {
-				return err
-			}
-			if ociConfig == nil || ociConfig.Process == nil || len(ociConfig.Process.Args) == 0 {
-				return errors.New("start: missing process")
-			}
-			s, err := state.Load(id)
-			if err != nil {
+	}
+	timeout := start.Flags().Duration("timeout", 0, "maximum time to wait for the container process to start (0 waits forever)")
+	start.RunE = func(cmd *cobra.Command, args []string) error {
+		disableUsage(cmd)
+		id := args[0]
+		ociConfig, err := oci.LoadConfig(id)
+		if err != nil {
+			return err
+		}
+		if ociConfig == nil || ociConfig.Process == nil || len(ociConfig.Process.Args) == 0 {
+			return errors.New("start: missing process")
+		}
+		s, err := state.Load(id)
+		if err != nil {
+			return err
+		}
+		backend, err := jail.GetBackend(s.Backend)
+		if err != nil {
+			return err
+		}
+		if s.Status == state.StatusRunning {
+			if ok, err := backend.IsRunning(cmd.Context(), id, s.PID); ok {
+				return errors.New("cannot start already running container")
+			} else if err != nil {
 				return err
 			}
-			if s.Status == state.StatusRunning {
-				if ok, err := jail.IsRunning(cmd.Context(), id, s.PID); ok {
-					return errors.New("cannot start already running container")
-				} else if err != nil {
-					return err
-				}
+		}
+		ctx := cmd.Context()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		err = jail.AwaitFifoOpen(ctx, id, func() (bool, error) {
+			return backend.IsRunning(ctx, id, s.PID)
+		})
+		var entrypointErr *jail.EntrypointError
+		if errors.As(err, &entrypointErr) || errors.Is(err, jail.ErrEntrypointExited) {
+			// the container process will never run
+			if saveErr := state.Update(id, func(s *state.State) error {
+				s.Status = state.StatusStopped
+				return nil
+			}); saveErr != nil {
+				logrus.WithError(saveErr).Warn("failed to save state")
 			}
-			err = jail.AwaitFifoOpen(cmd.Context(), id)
-			if err != nil {
-				return err
+			return err
+		} else if err != nil {
+			return err
+		}
+		// the container process may already have exited and had its exit
+		// recorded by the monitor, which must not be overwritten
+		return state.Update(id, func(s *state.State) error {
+			if s.Status == state.StatusCreated {
+				s.Status = state.StatusRunning
 			}
-			s.Status = state.StatusRunning
-			return s.Save()
-		},
+			return nil
+		})
 	}
+	return start
 }
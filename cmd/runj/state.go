@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"go.sbk.wtf/runj/jail"
 	"go.sbk.wtf/runj/runtimespec"
@@ -63,27 +64,24 @@ func stateCommand() *cobra.Command {
 				return err
 			}
 			if s.Status == state.StatusRunning {
-				ok, err := jail.IsRunning(cmd.Context(), id, s.PID)
+				backend, err := jail.GetBackend(s.Backend)
+				if err != nil {
+					return err
+				}
+				ok, err := backend.IsRunning(cmd.Context(), id, s.PID)
 				if err != nil {
 					return err
 				}
 				if !ok {
-					s.Status = state.StatusStopped
-					s.PID = 0
-					err = s.Save()
-					if err != nil {
+					if err := markStopped(id); err != nil {
+						return err
+					}
+					if s, err = state.Load(id); err != nil {
 						return err
 					}
 				}
 			}
-			output := StateOutput{
-				OCIVersion: runtimespec.Version,
-				ID:         id,
-				Status:     string(s.Status),
-				PID:        s.PID,
-				Bundle:     s.Bundle,
-			}
-			b, err := json.MarshalIndent(output, "", "  ")
+			b, err := json.MarshalIndent(stateOutput(s), "", "  ")
 			if err != nil {
 				return err
 			}
@@ -93,6 +91,19 @@ func stateCommand() *cobra.Command {
 	}
 }
 
+// markStopped records that a running container's process is gone.  The state
+// is reloaded under the state lock so that an exit recorded in the meantime is
+// kept.
+func markStopped(id string) error {
+	return state.Update(id, func(s *state.State) error {
+		if s.Status == state.StatusRunning {
+			s.Status = state.StatusStopped
+			s.PID = 0
+		}
+		return nil
+	})
+}
+
 /*
 {
     "ociVersion": "0.2.0",
@@ -112,4 +123,64 @@ type StateOutput struct {
 	PID         int               `json:"pid,omitempty"`
 	Bundle      string            `json:"bundle"`
 	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// OOMs is the number of rctl(8) memory limit notifications received for
+	// the jail.  This is a runj extension to the OCI state.
+	OOMs int `json:"ooms,omitempty"`
+	// LastOOM is the time of the most recent rctl(8) memory limit
+	// notification.  This is a runj extension to the OCI state.
+	LastOOM *time.Time `json:"lastOOM,omitempty"`
+	// JID is the numeric ID of the jail.  This is a runj extension to the OCI
+	// state.
+	JID int `json:"jid,omitempty"`
+	// Created is the time the container was created.  This is a runj
+	// extension to the OCI state.
+	Created *time.Time `json:"created,omitempty"`
+	// Owner is the user that created the container.  This is a runj extension
+	// to the OCI state.
+	Owner string `json:"owner,omitempty"`
+	// Rootfs is the resolved path to the jail's root filesystem.  This is a
+	// runj extension to the OCI state.
+	Rootfs string `json:"rootfs,omitempty"`
+	// ConfigDigest is the digest of the container's config.json.  This is a
+	// runj extension to the OCI state.
+	ConfigDigest string `json:"configDigest,omitempty"`
+	// ConsolePath is the path of the container process's pseudoterminal.
+	// This is a runj extension to the OCI state.
+	ConsolePath string `json:"consolePath,omitempty"`
+	// ExitStatus is the exit status of the container process, if its exit was
+	// recorded.  This is a runj extension to the OCI state.
+	ExitStatus *int `json:"exitStatus,omitempty"`
+	// ExitedAt is the time the container process exited, if its exit was
+	// recorded.  This is a runj extension to the OCI state.
+	ExitedAt *time.Time `json:"exitedAt,omitempty"`
+}
+
+// stateOutput converts the stored state of a container to its StateOutput.
+func stateOutput(s *state.State) *StateOutput {
+	output := &StateOutput{
+		OCIVersion:   runtimespec.Version,
+		ID:           s.ID,
+		Status:       string(s.Status),
+		PID:          s.PID,
+		Bundle:       s.Bundle,
+		Annotations:  s.Annotations,
+		OOMs:         s.OOMs,
+		JID:          s.JID,
+		Owner:        s.Owner,
+		Rootfs:       s.Rootfs,
+		ConfigDigest: s.ConfigDigest,
+		ConsolePath:  s.ConsolePath,
+	}
+	if !s.LastOOM.IsZero() {
+		output.LastOOM = &s.LastOOM
+	}
+	if !s.Created.IsZero() {
+		output.Created = &s.Created
+	}
+	if !s.ExitedAt.IsZero() {
+		output.ExitStatus = &s.ExitStatus
+		output.ExitedAt = &s.ExitedAt
+	}
+	return output
 }
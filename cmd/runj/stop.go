@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/state"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// killGracePeriod is how long processes are given to exit after the
+	// escalation to SIGKILL
+	killGracePeriod = 5 * time.Second
+	// exitPollInterval is how often the jail is checked for processes while
+	// waiting for them to exit
+	exitPollInterval = 100 * time.Millisecond
+)
+
+// stopCommand sends a signal to the container's init process and sends SIGKILL
+// to every process in the jail if they have not exited after a timeout.
+func stopCommand() *cobra.Command {
+	stop := &cobra.Command{
+		Use:   "stop <container-id>",
+		Short: "Stop a container",
+		Long: `Stop a container.  The signal (SIGTERM by default) is sent to the
+container's init process.  If processes are left in the container after the
+timeout, SIGKILL is sent to all of them.  Stopping a container that is already
+stopped has no effect.`,
+		Args: cobra.ExactArgs(1),
+	}
+	sigstr := stop.Flags().StringP("signal", "s", "SIGTERM", "signal sent to the init process")
+	timeout := stop.Flags().DurationP("timeout", "t", 10*time.Second, "time to wait for the container's processes to exit before sending SIGKILL")
+	stop.RunE = func(cmd *cobra.Command, args []string) error {
+		disableUsage(cmd)
+		id := args[0]
+		signal, err := parseSignal(*sigstr)
+		if err != nil {
+			return err
+		}
+		s, err := state.Load(id)
+		if err != nil {
+			return err
+		}
+		status, err := currentStatus(cmd.Context(), s)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case state.StatusStopped:
+			fmt.Fprintf(cmd.OutOrStdout(), "container %s is already stopped\n", id)
+			return nil
+		case state.StatusRunning:
+		default:
+			return errors.New("cannot stop a container that is not running")
+		}
+		backend, err := jail.GetBackend(s.Backend)
+		if err != nil {
+			return err
+		}
+		sentAt := time.Now()
+		if err := backend.Kill(cmd.Context(), id, s.PID, signal); err != nil {
+			return err
+		}
+		return awaitExit(cmd.Context(), cmd.OutOrStdout(), backend, id, s.PID, signal, sentAt, *timeout)
+	}
+	return stop
+}
+
+// awaitExit waits up to timeout for the container's processes to exit after
+// signal was sent at sentAt, then sends SIGKILL to every process left in the
+// jail.  What happened is reported to w.  An error is returned if processes are
+// left after SIGKILL.
+func awaitExit(ctx context.Context, w io.Writer, backend jail.Backend, id string, pid int, signal unix.Signal, sentAt time.Time, timeout time.Duration) error {
+	exited, err := waitForExit(ctx, backend, id, pid, timeout)
+	if err != nil {
+		return err
+	}
+	if exited {
+		return reportExit(ctx, w, id, signal, sentAt)
+	}
+	fmt.Fprintf(w, "container %s did not exit within %s after %s; sending SIGKILL to all processes\n", id, timeout, unix.SignalName(signal))
+	sentAt = time.Now()
+	if err := backend.Kill(ctx, id, -1, unix.SIGKILL); err != nil {
+		return err
+	}
+	exited, err = waitForExit(ctx, backend, id, pid, killGracePeriod)
+	if err != nil {
+		return err
+	}
+	if !exited {
+		return fmt.Errorf("container %s did not exit after SIGKILL", id)
+	}
+	return reportExit(ctx, w, id, unix.SIGKILL, sentAt)
+}
+
+// reportExit reports the exit of the container's processes to w.  For a
+// container created with --monitor, the exit status recorded by the monitor is
+// reported, so that an init process that exited on its own before the signal
+// was sent is not reported as stopped by it.
+func reportExit(ctx context.Context, w io.Writer, id string, signal unix.Signal, sentAt time.Time) error {
+	s, err := recordedExit(ctx, id, killGracePeriod)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s == nil:
+		fmt.Fprintf(w, "container %s exited after %s\n", id, unix.SignalName(signal))
+	case s.ExitedAt.Before(sentAt):
+		fmt.Fprintf(w, "container %s had already exited with status %d before %s was sent\n", id, s.ExitStatus, unix.SignalName(signal))
+	default:
+		fmt.Fprintf(w, "container %s exited with status %d after %s\n", id, s.ExitStatus, unix.SignalName(signal))
+	}
+	return nil
+}
+
+// recordedExit waits up to timeout for the monitor to record the exit of the
+// container's init process and returns the state with the exit.  It returns
+// nil if the container has no monitor or the exit was not recorded in time.
+func recordedExit(ctx context.Context, id string, timeout time.Duration) (*state.State, error) {
+	lock, err := os.Open(monitorLockPath(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+	// the monitor holds an exclusive lock until the exit is recorded
+	for {
+		err := unix.Flock(int(lock.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != unix.EWOULDBLOCK && err != unix.EINTR {
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s, err := state.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if s.ExitedAt.IsZero() {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// waitForExit polls until neither pid nor any other process is left in the
+// jail, and reports whether that happened within timeout.
+func waitForExit(ctx context.Context, backend jail.Backend, id string, pid int, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(exitPollInterval)
+	defer ticker.Stop()
+	for {
+		running, err := backend.IsRunning(ctx, id, pid)
+		if err != nil {
+			return false, err
+		}
+		if !running {
+			return true, nil
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
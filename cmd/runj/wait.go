@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"go.sbk.wtf/runj/state"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+// waitCommand blocks until a container created with `runj create --monitor`
+// exits and prints its exit status.
+func waitCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "wait <container-id>",
+		Short: "Wait for a container to exit and print its exit status",
+		Long: `Wait for a container to exit and print its exit status.  The container
+must have been created with "runj create --monitor".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			disableUsage(cmd)
+			id := args[0]
+			if _, err := state.Load(id); err != nil {
+				return err
+			}
+			lock, err := os.Open(monitorLockPath(id))
+			if os.IsNotExist(err) {
+				return fmt.Errorf("container %s was not created with --monitor", id)
+			} else if err != nil {
+				return err
+			}
+			defer lock.Close()
+			// the monitor holds an exclusive lock until the exit is recorded
+			for {
+				err = unix.Flock(int(lock.Fd()), unix.LOCK_SH)
+				if err != unix.EINTR {
+					break
+				}
+			}
+			if err != nil {
+				return err
+			}
+			s, err := state.Load(id)
+			if err != nil {
+				return err
+			}
+			if s.ExitedAt.IsZero() {
+				return errors.New("the monitor exited without recording the exit status")
+			}
+			fmt.Println(s.ExitStatus)
+			return nil
+		},
+	}
+}
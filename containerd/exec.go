@@ -6,8 +6,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -18,9 +21,91 @@ import (
 	"github.com/pkg/errors"
 )
 
+// command constructs an *exec.Cmd that invokes runj as configured by opts.
+func command(ctx context.Context, opts *Options, args ...string) *exec.Cmd {
+	binary := "runj"
+	if opts.BinaryPath != "" {
+		binary = opts.BinaryPath
+	}
+	var global []string
+	if opts.Root != "" {
+		global = append(global, "--root", opts.Root)
+	}
+	if opts.LogPath != "" {
+		global = append(global, "--log", opts.LogPath, "--log-format", "json")
+	}
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		global = append(global, "--debug")
+	}
+	return exec.CommandContext(ctx, binary, append(global, args...)...)
+}
+
+// runjError converts the result of a runj invocation into an error.  When runj
+// logged an error to opts.LogPath after offset, the last such message is used
+// to describe the failure.
+func runjError(opts *Options, offset int64, status int, err error) error {
+	if err == nil && status == 0 {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("runj exited with status %d", status)
+	}
+	if msg := lastLogError(opts.LogPath, offset); msg != "" {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return err
+}
+
+// logOffset returns the current size of the log file at path, which is the
+// offset where the next runj invocation starts writing.
+func logOffset(path string) int64 {
+	if path == "" {
+		return 0
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// lastLogError returns the message of the last error-level entry written to the
+// JSON-formatted log at path after offset.
+func lastLogError(path string, offset int64) string {
+	if path == "" {
+		return ""
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return ""
+	}
+	var msg string
+	dec := json.NewDecoder(f)
+	for {
+		var entry struct {
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			break
+		}
+		if entry.Level == logrus.ErrorLevel.String() {
+			msg = entry.Msg
+		}
+	}
+	return msg
+}
+
 // execCreate runs the "create" subcommand for runj
-func execCreate(ctx context.Context, id, bundle string, stdin io.Reader, stdout io.Writer, stderr io.Writer, terminal bool) (console.Console, error) {
+func execCreate(ctx context.Context, opts *Options, id, bundle string, stdin io.Reader, stdout io.Writer, stderr io.Writer, terminal bool) (console.Console, error) {
 	args := []string{"create", id, bundle}
+	if opts.DevfsRuleset != 0 {
+		args = append(args, "--devfs-ruleset", strconv.Itoa(opts.DevfsRuleset))
+	}
 	var socket *runc.Socket
 	if terminal {
 		log.G(ctx).WithField("id", id).Warn("Creating terminal!")
@@ -33,7 +118,7 @@ func execCreate(ctx context.Context, id, bundle string, stdin io.Reader, stdout
 		args = append(args, "--console-socket", socket.Path())
 	}
 
-	cmd := exec.CommandContext(ctx, "runj", args...)
+	cmd := command(ctx, opts, args...)
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
@@ -41,7 +126,11 @@ func execCreate(ctx context.Context, id, bundle string, stdin io.Reader, stdout
 		cmd.Stderr = log.G(ctx).WriterLevel(logrus.WarnLevel)
 	}
 	log.G(ctx).WithField("id", id).WithField("args", args).Warn("Starting runj create")
+	offset := logOffset(opts.LogPath)
 	ec, err := reaper.Default.Start(cmd)
+	if err != nil {
+		return nil, err
+	}
 
 	var con console.Console
 	if socket != nil {
@@ -57,7 +146,8 @@ func execCreate(ctx context.Context, id, bundle string, stdin io.Reader, stdout
 		log.G(ctx).WithField("id", id).Warn("Copying console!")
 	}
 
-	_, err = WaitNoFlush(cmd, ec)
+	status, err := WaitNoFlush(cmd, ec)
+	err = runjError(opts, offset, status, err)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("id", id).Error("runj create failed")
 	}
@@ -105,12 +195,16 @@ type ociState struct {
 	PID         int               `json:"pid,omitempty"`
 	Bundle      string            `json:"bundle"`
 	Annotations map[string]string `json:"annotations,omitempty"`
+	OOMs        int               `json:"ooms,omitempty"`
+	LastOOM     *time.Time        `json:"lastOOM,omitempty"`
+	ExitStatus  *int              `json:"exitStatus,omitempty"`
+	ExitedAt    *time.Time        `json:"exitedAt,omitempty"`
 }
 
 // execState runs the "state" subcommand for runj
-func execState(ctx context.Context, id string) (*ociState, error) {
-	cmd := exec.CommandContext(ctx, "runj", "state", id)
-	b, err := combinedOutput(cmd)
+func execState(ctx context.Context, opts *Options, id string) (*ociState, error) {
+	cmd := command(ctx, opts, "state", id)
+	b, err := combinedOutput(cmd, opts)
 	if err != nil {
 		log.G(ctx).
 			WithError(err).
@@ -124,9 +218,9 @@ func execState(ctx context.Context, id string) (*ociState, error) {
 }
 
 // execDelete runs the "delete" subcommand for runj
-func execDelete(ctx context.Context, id string) error {
-	cmd := exec.CommandContext(ctx, "runj", "delete", id)
-	b, err := combinedOutput(cmd)
+func execDelete(ctx context.Context, opts *Options, id string) error {
+	cmd := command(ctx, opts, "delete", id)
+	b, err := combinedOutput(cmd, opts)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("output", string(b)).WithField("id", id).Error("runj delete failed")
 		return err
@@ -135,13 +229,13 @@ func execDelete(ctx context.Context, id string) error {
 }
 
 // execKill runs the "kill" subcommand for runj
-func execKill(ctx context.Context, id string, signal string, all bool) error {
+func execKill(ctx context.Context, opts *Options, id string, signal string, all bool) error {
 	args := []string{"kill", id, signal}
 	if all {
 		args = append(args, "--all")
 	}
-	cmd := exec.CommandContext(ctx, "runj", args...)
-	b, err := combinedOutput(cmd)
+	cmd := command(ctx, opts, args...)
+	b, err := combinedOutput(cmd, opts)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("output", string(b)).WithField("id", id).Error("runj kill failed")
 		return err
@@ -150,9 +244,9 @@ func execKill(ctx context.Context, id string, signal string, all bool) error {
 }
 
 // execStart runs the "start" subcommand for runj
-func execStart(ctx context.Context, id string) error {
-	cmd := exec.CommandContext(ctx, "runj", "start", id)
-	b, err := combinedOutput(cmd)
+func execStart(ctx context.Context, opts *Options, id string) error {
+	cmd := command(ctx, opts, "start", id)
+	b, err := combinedOutput(cmd, opts)
 	if err != nil {
 		log.G(ctx).WithError(err).WithField("output", string(b)).WithField("id", id).Error("runj start failed")
 		return err
@@ -160,12 +254,19 @@ func execStart(ctx context.Context, id string) error {
 	return nil
 }
 
-func combinedOutput(cmd *exec.Cmd) ([]byte, error) {
+// combinedOutput runs the command and returns its combined stdout and stderr.
+// If the command fails, the returned error includes the last error runj wrote
+// to opts.LogPath.
+func combinedOutput(cmd *exec.Cmd, opts *Options) ([]byte, error) {
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stdout
+	offset := logOffset(opts.LogPath)
 	ec, err := reaper.Default.Start(cmd)
-	_, err = reaper.Default.Wait(cmd, ec)
+	if err != nil {
+		return nil, err
+	}
+	status, err := reaper.Default.Wait(cmd, ec)
 	b := stdout.Bytes()
-	return b, err
+	return b, runjError(opts, offset, status, err)
 }
@@ -0,0 +1,220 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/sys/reaper"
+	"github.com/containerd/fifo"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+)
+
+// binaryIOTimeout is how long a logging binary is given to exit after SIGTERM
+// before it is killed
+const binaryIOTimeout = 12 * time.Second
+
+// processIO is the stdio of a container process
+type processIO struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// closers are closed when the process exits
+	closers []io.Closer
+}
+
+// Close closes the stdio of the process.
+func (p *processIO) Close() error {
+	var err error
+	for _, c := range p.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// openIO opens the stdio described by a CreateTaskRequest.  stdin is a fifo.
+// stdout is either a fifo path (optionally with the fifo:// scheme), a
+// binary:// URI naming a logging binary, or a file:// URI naming a file, as
+// accepted by containerd-shim-runc-v2.  With the binary and file schemes, the
+// process's stdout and stderr are both sent to the logging binary or file and
+// stderr is ignored.  Paths that do not exist are left unopened.
+func openIO(ctx context.Context, id, stdin, stdout, stderr string) (_ *processIO, err error) {
+	pio := &processIO{}
+	defer func() {
+		if err != nil {
+			pio.Close()
+		}
+	}()
+	if _, err := os.Stat(stdin); err == nil {
+		f, err := fifo.OpenFifo(ctx, stdin, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return nil, err
+		}
+		pio.closers = append(pio.closers, f)
+		pio.stdin = f
+	}
+	if stdout == "" {
+		return pio, nil
+	}
+	u, err := url.Parse(stdout)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse stdout uri")
+	}
+	switch u.Scheme {
+	case "", "fifo":
+		if u.Scheme == "" {
+			u.Path = stdout
+		}
+		for _, s := range []struct {
+			path string
+			w    *io.Writer
+		}{{u.Path, &pio.stdout}, {stderr, &pio.stderr}} {
+			if _, err := os.Stat(s.path); err != nil {
+				continue
+			}
+			f, err := fifo.OpenFifo(ctx, s.path, syscall.O_WRONLY, 0)
+			if err != nil {
+				return nil, err
+			}
+			pio.closers = append(pio.closers, f)
+			*s.w = f
+		}
+	case "binary":
+		b, err := newBinaryIO(ctx, id, u)
+		if err != nil {
+			return nil, err
+		}
+		pio.closers = append(pio.closers, b)
+		pio.stdout, pio.stderr = b.out, b.err
+	case "file":
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(u.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		pio.closers = append(pio.closers, f)
+		pio.stdout, pio.stderr = f, f
+	default:
+		return nil, fmt.Errorf("unknown STDIO scheme %s", u.Scheme)
+	}
+	return pio, nil
+}
+
+// binaryIO is a logging binary that receives the output of a container process.
+// The binary is started with the read ends of pipes for stdout and stderr as
+// fds 3 and 4 and a pipe as fd 5 that it closes once it is ready, following
+// containerd's runtime/v2/logging protocol.
+type binaryIO struct {
+	cmd      *exec.Cmd
+	ec       chan runc.Exit
+	out, err *os.File
+}
+
+func newBinaryIO(ctx context.Context, id string, uri *url.URL) (_ *binaryIO, err error) {
+	ns, err := namespaces.NamespaceRequired(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for k, vs := range uri.Query() {
+		args = append(args, k)
+		if len(vs) > 0 {
+			args = append(args, vs[0])
+		}
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	outr, outw, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create stdout pipes")
+	}
+	files = append(files, outr, outw)
+	errr, errw, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create stderr pipes")
+	}
+	files = append(files, errr, errw)
+	readyr, readyw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, readyr, readyw)
+
+	cmd := exec.Command(uri.Path, args...)
+	cmd.Env = append(cmd.Env,
+		"CONTAINER_ID="+id,
+		"CONTAINER_NAMESPACE="+ns,
+	)
+	cmd.ExtraFiles = []*os.File{outr, errr, readyw}
+	// the shim reaps all of its children, so the exit status is received
+	// through the reaper
+	ec, err := reaper.Default.Start(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start logging binary")
+	}
+	b := &binaryIO{cmd: cmd, ec: ec}
+	// the logging binary has its own copies of these
+	readyw.Close()
+	outr.Close()
+	errr.Close()
+	files = []*os.File{readyr, outw, errw}
+
+	// wait for the logging binary to be ready
+	if _, err := readyr.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		b.stop()
+		return nil, errors.Wrap(err, "failed to read from logging binary")
+	}
+	readyr.Close()
+	b.out, b.err = outw, errw
+	files = nil
+	return b, nil
+}
+
+// Close closes the pipes to the logging binary and stops it.
+func (b *binaryIO) Close() error {
+	b.out.Close()
+	b.err.Close()
+	return b.stop()
+}
+
+// stop sends SIGTERM to the logging binary so that it can flush its output and
+// exit, and kills it if it has not exited after binaryIOTimeout.
+func (b *binaryIO) stop() error {
+	if err := b.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.L.WithError(err).Warn("failed to send SIGTERM to logging binary, killing")
+		b.cmd.Process.Kill()
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := WaitNoFlush(b.cmd, b.ec)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(binaryIOTimeout):
+		log.L.Warn("timed out waiting for logging binary to exit, killing")
+		if err := b.cmd.Process.Kill(); err != nil {
+			return errors.Wrap(err, "failed to kill logging binary")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/containerd/namespaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenIOFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runj-shim-io")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logs", "container.log")
+
+	pio, err := openIO(context.Background(), "test", "", "file://"+path, "")
+	require.NoError(t, err)
+	assert.Nil(t, pio.stdin)
+	fmt.Fprintln(pio.stdout, "out")
+	fmt.Fprintln(pio.stderr, "err")
+	require.NoError(t, pio.Close())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "out\nerr\n", string(b))
+}
+
+func TestOpenIOBinary(t *testing.T) {
+	startReaper(t)
+	dir, err := ioutil.TempDir("", "runj-shim-io")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	logger := filepath.Join(dir, "logger")
+	// the logger ignores SIGTERM so that it exits once its stdout pipe is
+	// closed, after copying everything written to it
+	require.NoError(t, ioutil.WriteFile(logger, []byte(`#!/bin/sh
+trap '' TERM
+echo "$CONTAINER_NAMESPACE/$CONTAINER_ID" > "$2"
+exec 5>&-
+cat <&3 >> "$2"
+`), 0755))
+
+	ctx := namespaces.WithNamespace(context.Background(), "default")
+	pio, err := openIO(ctx, "test", "", "binary://"+logger+"?out="+out, "")
+	require.NoError(t, err)
+	b, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "default/test\n", string(b), "the logger should be ready before openIO returns")
+
+	fmt.Fprintln(pio.stdout, "hello")
+	require.NoError(t, pio.Close())
+	b, err = ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "default/test\nhello\n", string(b))
+}
+
+func TestOpenIOUnknownScheme(t *testing.T) {
+	_, err := openIO(context.Background(), "test", "", "tcp://localhost:1234", "")
+	assert.Error(t, err)
+}
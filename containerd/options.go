@@ -0,0 +1,101 @@
+package containerd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	runtimeoptions "github.com/containerd/containerd/pkg/runtimeoptions/v1"
+	"github.com/containerd/typeurl"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+const optionsFilename = "options.json"
+
+func init() {
+	typeurl.Register(&Options{}, "go.sbk.wtf/runj/containerd", "Options")
+}
+
+// Options are the runtime options understood by the runj shim.  Options can be
+// provided directly in CreateTaskRequest.Options or, when using containerd's
+// CRI plugin, as the JSON file named by the config_path of the runtime's
+// options in containerd's configuration:
+//
+//	[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runj]
+//	  runtime_type = "wtf.sbk.runj.v1"
+//	  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runj.options]
+//	    config_path = "/usr/local/etc/containerd/runj.json"
+type Options struct {
+	// BinaryPath is the path to the runj binary.  If empty, runj is found in
+	// PATH.
+	BinaryPath string `json:"binary_path,omitempty"`
+	// Root is the directory where runj keeps jail state.  If empty, runj's
+	// default is used.
+	Root string `json:"root,omitempty"`
+	// LogLevel is the logrus level used by the shim, like "debug" or "warn".
+	LogLevel string `json:"log_level,omitempty"`
+	// LogPath is the file runj writes its JSON-formatted logs to.  If empty,
+	// log.json in the bundle is used.  The last error logged by a failed runj
+	// invocation is included in the error returned by the shim.
+	LogPath string `json:"log_path,omitempty"`
+	// DevfsRuleset is the devfs(8) ruleset applied to the jail's /dev.  If
+	// zero, runj's default is used.
+	DevfsRuleset int `json:"devfs_ruleset,omitempty"`
+}
+
+// decodeOptions decodes the Options provided in a CreateTaskRequest.
+func decodeOptions(any *ptypes.Any) (*Options, error) {
+	opts := &Options{}
+	if any == nil || any.TypeUrl == "" {
+		return opts, nil
+	}
+	v, err := typeurl.UnmarshalAny(any)
+	if err != nil {
+		return nil, fmt.Errorf("options: %w", err)
+	}
+	switch o := v.(type) {
+	case *Options:
+		return o, nil
+	case *runtimeoptions.Options:
+		if o.ConfigPath == "" {
+			return opts, nil
+		}
+		data, err := ioutil.ReadFile(o.ConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("options: %w", err)
+		}
+		if err := json.Unmarshal(data, opts); err != nil {
+			return nil, fmt.Errorf("options: failed to parse %q: %w", o.ConfigPath, err)
+		}
+		return opts, nil
+	}
+	return nil, fmt.Errorf("options: unsupported type %T", v)
+}
+
+// writeOptions stores the Options in the bundle so that they are available to
+// the shim's delete command, which runs in a separate process.
+func writeOptions(bundlePath string, opts *Options) error {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(bundlePath, optionsFilename), data, 0600)
+}
+
+// readOptions reads the Options stored in the bundle by writeOptions.  The
+// zero Options are returned if none were stored.
+func readOptions(bundlePath string) (*Options, error) {
+	opts := &Options{}
+	data, err := ioutil.ReadFile(filepath.Join(bundlePath, optionsFilename))
+	if os.IsNotExist(err) {
+		return opts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
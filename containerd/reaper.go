@@ -5,31 +5,18 @@ import (
 	"os"
 	"os/signal"
 
+	"go.sbk.wtf/runj/procctl"
+
 	"github.com/containerd/containerd/sys/reaper"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
 
-// Consider contributing the following to upstream containerd
-
 // SetReaper sets this process as the reaper for its orphaned descendant processes
 func SetReaper() error {
-	return procReapAcquire()
+	return procctl.ReapAcquire()
 }
 
-const (
-	_P_PID             = 0 // https://github.com/freebsd/freebsd-src/blob/098dbd7ff7f3da9dda03802cdb2d8755f816eada/sys/sys/wait.h#L109
-	_PROC_REAP_ACQUIRE = 2 // https://github.com/freebsd/freebsd-src/blob/098dbd7ff7f3da9dda03802cdb2d8755f816eada/sys/sys/procctl.h#L49
-)
-
-func procReapAcquire() error {
-	pid := unix.Getpid()
-	_, _, err := unix.Syscall(unix.SYS_PROCCTL, _P_PID, uintptr(pid), _PROC_REAP_ACQUIRE)
-	return err
-}
-
-// End section for upstream contribution
-
 // SetupReaperSignals configures the current process as a reaper, sets up a
 // signal handler to receive SIGCHLD,and processes SIGCHLD events with
 // containerd's reaper package.
@@ -2,7 +2,6 @@ package containerd
 
 import (
 	"context"
-	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,6 +12,7 @@ import (
 
 	"github.com/containerd/console"
 
+	"go.sbk.wtf/runj/jail"
 	"go.sbk.wtf/runj/state"
 
 	"github.com/containerd/containerd/api/events"
@@ -27,7 +27,6 @@ import (
 	"github.com/containerd/containerd/runtime/v2/task"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
 	"github.com/containerd/containerd/sys/reaper"
-	"github.com/containerd/fifo"
 	runc "github.com/containerd/go-runc"
 	"github.com/gogo/protobuf/types"
 	ptypes "github.com/gogo/protobuf/types"
@@ -48,6 +47,7 @@ func NewService(ctx context.Context, id string, publisher shim.Publisher, shutdo
 		primary: managedProcess{
 			waitblock: make(chan struct{}, 0),
 		},
+		opts: &Options{},
 	}
 
 	if address, err := shim.ReadAddress("address"); err == nil {
@@ -81,11 +81,14 @@ func (s *service) checkProcesses(e runc.Exit) {
 	log.G(s.context).WithField("pid", e.Pid).Warn("INIT EXITED!")
 
 	// Ensure all children are killed
-	err := execKill(s.context, s.id, "KILL", true)
+	err := execKill(s.context, s.getOptions(), s.id, "KILL", true)
 	if err != nil {
 		logrus.WithError(err).WithField("id", s.id).Error("failed to kill init's children")
 	}
 	s.primary.SetExited(e)
+	if err := state.RecordExit(s.id, e.Status, e.Timestamp); err != nil {
+		log.G(s.context).WithError(err).WithField("id", s.id).Error("failed to record exit")
+	}
 	s.sendL(&events.TaskExit{
 		ContainerID: s.id,
 		ID:          s.id,
@@ -100,6 +103,28 @@ func (s *service) checkProcesses(e runc.Exit) {
 	close(s.primary.waitblock)
 }
 
+// processRctl publishes TaskOOM events for rctl(8) memory limit notifications
+// received for the jail and records them in the jail's state.
+func (s *service) processRctl(ctx context.Context) {
+	rctl, err := jail.SubscribeRctl(ctx, s.id)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("id", s.id).Warn("rctl notifications unavailable")
+		return
+	}
+	for e := range rctl {
+		if !e.IsMemory() {
+			continue
+		}
+		log.G(ctx).WithField("rule", e.Rule).WithField("pid", e.PID).Info("jail exceeded its rctl memory limit")
+		if err := state.RecordOOM(s.id, time.Now()); err != nil {
+			log.G(ctx).WithError(err).WithField("id", s.id).Error("failed to record OOM")
+		}
+		s.sendL(&events.TaskOOM{
+			ContainerID: s.id,
+		})
+	}
+}
+
 // forward forwards events to the shim.Publisher
 func (s *service) forward(ctx context.Context, publisher shim.Publisher) {
 	ns, _ := namespaces.Namespace(ctx)
@@ -164,6 +189,8 @@ type service struct {
 
 	mu         sync.Mutex
 	bundlePath string
+	// opts are the runtime options provided when the task was created
+	opts *Options
 	// primary is the primary process for the jail.  The lifetime of the jail
 	// is tied to this process.
 	primary managedProcess
@@ -280,7 +307,11 @@ func (s *service) Cleanup(ctx context.Context) (*task.DeleteResponse, error) {
 	if !ok {
 		return nil, errors.New("failed to read opts")
 	}
-	return s.delete(ctx, opts.BundlePath)
+	runjOpts, err := readOptions(opts.BundlePath)
+	if err != nil {
+		return nil, err
+	}
+	return s.delete(ctx, opts.BundlePath, runjOpts)
 }
 
 // Delete a process or container.  When deleting a container, Delete should call
@@ -302,16 +333,28 @@ func (s *service) Delete(ctx context.Context, req *task.DeleteRequest) (*task.De
 		return nil, errdefs.ErrFailedPrecondition
 	}
 
-	return s.delete(ctx, path)
+	resp, err := s.delete(ctx, path, s.getOptions())
+	if err != nil {
+		return nil, err
+	}
+	s.sendL(&events.TaskDelete{
+		ContainerID: s.id,
+		Pid:         resp.Pid,
+		ExitStatus:  resp.ExitStatus,
+		ExitedAt:    resp.ExitedAt,
+	})
+	return resp, nil
 }
 
 // delete performs work that is common between Cleanup and Delete.
-func (s *service) delete(ctx context.Context, bundlePath string) (*task.DeleteResponse, error) {
-	if err := execKill(ctx, s.id, "KILL", true); err != nil {
+func (s *service) delete(ctx context.Context, bundlePath string, opts *Options) (*task.DeleteResponse, error) {
+	if err := execKill(ctx, opts, s.id, "KILL", true); err != nil {
 		log.G(ctx).WithError(err).Error("failed to run runj kill --all")
 		return nil, err
 	}
-	if err := execDelete(ctx, s.id); err != nil {
+	// the exit status must be read before runj delete removes the state
+	exit := s.primaryExit(ctx, opts)
+	if err := execDelete(ctx, opts, s.id); err != nil {
 		log.G(ctx).WithError(err).Error("failed to run runj delete")
 		return nil, err
 	}
@@ -319,11 +362,50 @@ func (s *service) delete(ctx context.Context, bundlePath string) (*task.DeleteRe
 		log.G(ctx).WithError(err).Warn("failed to cleanup rootfs mount")
 	}
 	return &taskAPI.DeleteResponse{
-		ExitedAt:   time.Now(),
-		ExitStatus: 128 + uint32(unix.SIGKILL),
+		Pid:        uint32(exit.Pid),
+		ExitedAt:   exit.Timestamp,
+		ExitStatus: uint32(exit.Status),
 	}, nil
 }
 
+// deleteExitTimeout is how long delete waits for the primary process to exit
+// after it is killed
+const deleteExitTimeout = 2 * time.Second
+
+// primaryExit returns the exit details of the primary process.  The exit
+// observed by this shim is preferred; a shim started for Cleanup has not
+// observed the exit, but runj may have recorded it.  When neither is known,
+// the process is reported as killed by SIGKILL now, as delete kills it.
+func (s *service) primaryExit(ctx context.Context, opts *Options) runc.Exit {
+	if s.primary.GetPID() != 0 {
+		// the exit is recorded by checkProcesses
+		select {
+		case <-s.primary.waitblock:
+			return s.primary.GetExited()
+		case <-time.After(deleteExitTimeout):
+			log.G(ctx).WithField("id", s.id).Warn("timed out waiting for exit")
+		}
+	}
+	exit := runc.Exit{
+		Pid:       s.primary.GetPID(),
+		Status:    128 + int(unix.SIGKILL),
+		Timestamp: time.Now(),
+	}
+	ociState, err := execState(ctx, opts, s.id)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("id", s.id).Warn("failed to read exit status")
+		return exit
+	}
+	if exit.Pid == 0 {
+		exit.Pid = ociState.PID
+	}
+	if ociState.ExitedAt != nil && ociState.ExitStatus != nil {
+		exit.Timestamp = *ociState.ExitedAt
+		exit.Status = *ociState.ExitStatus
+	}
+	return exit
+}
+
 // Create sets up the OCI bundle and invokes runj create
 func (s *service) Create(ctx context.Context, req *task.CreateTaskRequest) (*task.CreateTaskResponse, error) {
 	log.G(ctx).WithField("req", req).Warn("CREATE")
@@ -333,6 +415,26 @@ func (s *service) Create(ctx context.Context, req *task.CreateTaskRequest) (*tas
 	}
 	s.setBundlePath(req.Bundle)
 
+	opts, err := decodeOptions(req.Options)
+	if err != nil {
+		log.G(ctx).WithError(err).Error("failed to decode options")
+		return nil, err
+	}
+	if opts.LogLevel != "" {
+		level, err := logrus.ParseLevel(opts.LogLevel)
+		if err != nil {
+			return nil, errors.Wrap(err, "options: invalid log level")
+		}
+		logrus.SetLevel(level)
+	}
+	if opts.LogPath == "" {
+		opts.LogPath = filepath.Join(req.Bundle, "log.json")
+	}
+	if err := writeOptions(req.Bundle, opts); err != nil {
+		return nil, err
+	}
+	s.setOptions(opts)
+
 	var mounts []process.Mount
 	for _, m := range req.Rootfs {
 		mounts = append(mounts, process.Mount{
@@ -351,7 +453,6 @@ func (s *service) Create(ctx context.Context, req *task.CreateTaskRequest) (*tas
 			return nil, err
 		}
 	}
-	var err error
 	defer func() {
 		if err != nil {
 			log.G(ctx).WithField("rootfs", rootfs).WithError(err).Error("failed to create,unmounting rootfs")
@@ -372,51 +473,26 @@ func (s *service) Create(ctx context.Context, req *task.CreateTaskRequest) (*tas
 		}
 	}
 
-	var closeOnErr []io.Closer
-	defer func() {
-		if err == nil {
-			return
-		}
-		for _, c := range closeOnErr {
-			c.Close()
-		}
-	}()
-	var (
-		stdin  io.ReadWriteCloser
-		stdout io.ReadWriteCloser
-		stderr io.ReadWriteCloser
-	)
-	if _, err := os.Stat(req.Stdin); err == nil {
-		stdin, err = fifo.OpenFifo(ctx, req.Stdin, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
-		if err != nil {
-			return nil, err
-		}
-		closeOnErr = append(closeOnErr, stdin)
-	}
-	if _, err := os.Stat(req.Stdout); err == nil {
-		stdout, err = fifo.OpenFifo(ctx, req.Stdout, syscall.O_WRONLY, 0)
-		if err != nil {
-			return nil, err
-		}
-		closeOnErr = append(closeOnErr, stdout)
+	pio, err := openIO(ctx, req.ID, req.Stdin, req.Stdout, req.Stderr)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := os.Stat(req.Stderr); err == nil {
-		stderr, err = fifo.OpenFifo(ctx, req.Stderr, syscall.O_WRONLY, 0)
+	defer func() {
 		if err != nil {
-			return nil, err
+			pio.Close()
 		}
-		closeOnErr = append(closeOnErr, stderr)
-	}
+	}()
 
-	con, err := execCreate(ctx, req.ID, req.Bundle, stdin, stdout, stderr, req.Terminal)
+	con, err := execCreate(ctx, opts, req.ID, req.Bundle, pio.stdin, pio.stdout, pio.stderr, req.Terminal)
 	if err != nil {
 		log.G(ctx).WithError(err).Error("failed to create jail")
 		return nil, err
 	}
-	s.primary.SetStdioFifo(closeOnErr)
+	s.primary.SetStdioFifo(pio.closers)
 	s.primary.SetConsole(con)
+	go s.processRctl(s.context)
 
-	ociState, err := execState(ctx, req.ID)
+	ociState, err := execState(ctx, opts, req.ID)
 	if err != nil {
 		log.G(ctx).WithError(err).Error("failed to get jail state")
 		return nil, err
@@ -455,6 +531,27 @@ func (s *service) getBundlePath() string {
 	return s.bundlePath
 }
 
+func (s *service) setOptions(opts *Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.opts = opts
+	// the shim updates runj's state directly to record OOMs and exits, so it
+	// must use the same root as runj
+	root := state.DefaultRoot
+	if opts.Root != "" {
+		root = opts.Root
+	}
+	state.SetRoot(root)
+}
+
+func (s *service) getOptions() *Options {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.opts
+}
+
 // sendUnsafe sends an event without acquiring the event lock
 func (s *service) sendUnsafe(evt interface{}) {
 	s.events <- evt
@@ -479,7 +576,7 @@ func (s *service) State(ctx context.Context, req *task.StateRequest) (*task.Stat
 		return nil, errdefs.ErrInvalidArgument
 	}
 	bundlePath := s.getBundlePath()
-	ociState, err := execState(ctx, s.id)
+	ociState, err := execState(ctx, s.getOptions(), s.id)
 	if err != nil {
 		return nil, err
 	}
@@ -490,10 +587,18 @@ func (s *service) State(ctx context.Context, req *task.StateRequest) (*task.Stat
 		Status: runjStatusToContainerdStatus(ociState.Status),
 	}
 	log.G(ctx).WithField("state", ociState).WithField("resp", resp).Warn("STATE")
+	// StateResponse has no field for annotations
+	log.G(ctx).WithField("annotations", ociState.Annotations).Debug("jail annotations")
 	if resp.Status == tasktypes.StatusStopped {
 		exit := s.primary.GetExited()
 		resp.ExitedAt = exit.Timestamp
 		resp.ExitStatus = uint32(exit.Status)
+		// a restarted shim has not observed the exit, but runj may have
+		// recorded it
+		if exit.Timestamp.IsZero() && ociState.ExitedAt != nil && ociState.ExitStatus != nil {
+			resp.ExitedAt = *ociState.ExitedAt
+			resp.ExitStatus = uint32(*ociState.ExitStatus)
+		}
 	}
 	return resp, nil
 }
@@ -522,7 +627,8 @@ func (s *service) Start(ctx context.Context, req *task.StartRequest) (*task.Star
 		log.G(ctx).WithField("reqID", req.ID).WithField("id", s.id).Error("mismatched IDs")
 		return nil, errdefs.ErrInvalidArgument
 	}
-	ociState, err := execState(ctx, s.id)
+	opts := s.getOptions()
+	ociState, err := execState(ctx, opts, s.id)
 	if err != nil {
 		return nil, err
 	}
@@ -530,7 +636,7 @@ func (s *service) Start(ctx context.Context, req *task.StartRequest) (*task.Star
 	// hold the sendUnsafe lock so that the start events are sent before any exit events in the error case
 	s.eventSendMu.Lock()
 	defer s.eventSendMu.Unlock()
-	err = execStart(ctx, s.id)
+	err = execStart(ctx, opts, s.id)
 	if err != nil {
 		return nil, err
 	}
@@ -574,7 +680,7 @@ func (s *service) Kill(ctx context.Context, req *task.KillRequest) (*types.Empty
 		log.G(ctx).WithField("reqID", req.ID).WithField("id", s.id).Error("mismatched IDs")
 		return nil, errdefs.ErrInvalidArgument
 	}
-	err := execKill(ctx, s.id, strconv.FormatUint(uint64(req.Signal), 10), req.All)
+	err := execKill(ctx, s.getOptions(), s.id, strconv.FormatUint(uint64(req.Signal), 10), req.All)
 	return nil, err
 }
 
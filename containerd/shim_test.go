@@ -0,0 +1,157 @@
+package containerd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sbk.wtf/runj/state"
+
+	"github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/runtime/v2/shim"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/containerd/sys/reaper"
+	runc "github.com/containerd/go-runc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// fakeRunj is a runj binary that succeeds for kill and delete and prints
+// state.json from its directory for state.  Each invocation is appended to
+// calls in its directory.
+const fakeRunj = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$@" >> "$dir/calls"
+for arg; do
+	case "$arg" in
+	state) cat "$dir/state.json"; exit 0 ;;
+	kill|delete) exit 0 ;;
+	esac
+done
+exit 1
+`
+
+// startReaper reaps the test's child processes through the reaper, as
+// SetupReaperSignals does for the shim.
+func startReaper(t *testing.T) {
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals, unix.SIGCHLD)
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		signal.Stop(signals)
+		close(done)
+	})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+				reaper.Reap()
+			}
+		}
+	}()
+}
+
+// testService returns a service whose runj is fakeRunj, with ociState written
+// as the output of runj state.
+func testService(t *testing.T, ociState string) (*service, string) {
+	startReaper(t)
+	dir, err := ioutil.TempDir("", "runj-shim")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	binary := filepath.Join(dir, "runj")
+	require.NoError(t, ioutil.WriteFile(binary, []byte(fakeRunj), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte(ociState), 0644))
+	s := &service{
+		id:      "test",
+		context: context.Background(),
+		events:  make(chan interface{}, 128),
+		exits:   reaper.Default.Subscribe(),
+		primary: managedProcess{
+			waitblock: make(chan struct{}, 0),
+		},
+		opts:       &Options{BinaryPath: binary},
+		bundlePath: dir,
+	}
+	t.Cleanup(func() { reaper.Default.Unsubscribe(s.exits) })
+	return s, dir
+}
+
+func TestDeleteExited(t *testing.T) {
+	s, dir := testService(t, `{"id":"test","status":"stopped"}`)
+	// checkProcesses also records the exit in runj's state
+	state.SetRoot(dir)
+	defer state.SetRoot(state.DefaultRoot)
+	require.NoError(t, s.primary.SetPID(42))
+	exitedAt := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	s.checkProcesses(runc.Exit{Pid: 42, Status: 3, Timestamp: exitedAt})
+
+	resp, err := s.Delete(context.Background(), &taskAPI.DeleteRequest{ID: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), resp.Pid)
+	assert.Equal(t, uint32(3), resp.ExitStatus)
+	assert.Equal(t, exitedAt, resp.ExitedAt)
+
+	require.Len(t, s.events, 2)
+	assert.IsType(t, &events.TaskExit{}, <-s.events)
+	assert.Equal(t, &events.TaskDelete{
+		ContainerID: "test",
+		Pid:         42,
+		ExitStatus:  3,
+		ExitedAt:    exitedAt,
+	}, <-s.events)
+
+	calls, err := ioutil.ReadFile(filepath.Join(dir, "calls"))
+	require.NoError(t, err)
+	assert.Equal(t, "kill test KILL --all\nkill test KILL --all\ndelete test\n", string(calls))
+}
+
+func TestCleanupRecordedExit(t *testing.T) {
+	// a shim started for cleanup has not observed the exit
+	s, dir := testService(t, `{"id":"test","status":"stopped","pid":42,"exitStatus":7,"exitedAt":"2021-04-01T12:00:00Z"}`)
+	require.NoError(t, writeOptions(dir, s.opts))
+	ctx := context.WithValue(context.Background(), shim.OptsKey{}, shim.Opts{BundlePath: dir})
+
+	resp, err := s.Cleanup(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), resp.Pid)
+	assert.Equal(t, uint32(7), resp.ExitStatus)
+	assert.Equal(t, time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC), resp.ExitedAt.UTC())
+	assert.Len(t, s.events, 0, "cleanup should not publish events")
+}
+
+func TestDeleteUnknownExit(t *testing.T) {
+	s, _ := testService(t, `{"id":"test","status":"created"}`)
+	before := time.Now()
+
+	resp, err := s.Delete(context.Background(), &taskAPI.DeleteRequest{ID: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(128+unix.SIGKILL), resp.ExitStatus)
+	assert.False(t, resp.ExitedAt.Before(before))
+	require.Len(t, s.events, 1)
+	assert.IsType(t, &events.TaskDelete{}, <-s.events)
+}
+
+func TestStateRecordedExit(t *testing.T) {
+	s, _ := testService(t, `{"id":"test","status":"stopped","pid":42,"exitStatus":7,"exitedAt":"2021-04-01T12:00:00Z"}`)
+	resp, err := s.State(context.Background(), &taskAPI.StateRequest{ID: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), resp.ExitStatus)
+	assert.Equal(t, time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC), resp.ExitedAt.UTC())
+}
+
+func TestStateExitWithoutStatus(t *testing.T) {
+	// runj only reports exitedAt with exitStatus, but the shim must not rely
+	// on it
+	s, _ := testService(t, `{"id":"test","status":"stopped","exitedAt":"2021-04-01T12:00:00Z"}`)
+	resp, err := s.State(context.Background(), &taskAPI.StateRequest{ID: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), resp.ExitStatus)
+	assert.True(t, resp.ExitedAt.IsZero())
+}
@@ -0,0 +1,268 @@
+/*
+Package containerlog writes the output of a container process to a log file, so
+that the output of containers run without containerd is kept after `runj
+create` exits.  Each line of output is written as an entry with a timestamp and
+the name of the stream, in either the CRI log format used by the kubelet or the
+JSON lines format used by Docker's json-file driver.  The file can be rotated
+when it reaches a maximum size.
+*/
+package containerlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the format of log entries.
+type Format string
+
+const (
+	// FormatCRI writes entries like
+	// "2016-10-06T00:17:09.669794202Z stdout F log content", where the tag is
+	// P for a partial line and F for the end of a line
+	FormatCRI Format = "cri"
+	// FormatJSON writes entries like
+	// {"log":"log content\n","stream":"stdout","time":"2016-10-06T00:17:09.669794202Z"}
+	FormatJSON Format = "json"
+
+	// maxLineSize is the longest line written as a single entry.  Longer
+	// lines are split into partial entries.
+	maxLineSize = 16 * 1024
+)
+
+// ParseFormat parses the name of a Format.
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatCRI, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q (expected %q or %q)", format, FormatCRI, FormatJSON)
+}
+
+// Config describes a log file.
+type Config struct {
+	// Path is the path of the log file
+	Path string
+	// Format is the format of the entries
+	Format Format
+	// MaxSize is the size in bytes at which the file is rotated.  Zero
+	// disables rotation.
+	MaxSize int64
+	// MaxFiles is the number of files kept, including the current file.
+	// Rotated files are named Path.1 (the most recent) through
+	// Path.<MaxFiles-1>.  With fewer than two files, the file is truncated
+	// when it is rotated.
+	MaxFiles int
+}
+
+// Logger writes log entries for one or more streams to a file.  It is safe for
+// concurrent use.
+type Logger struct {
+	config Config
+	mu     sync.Mutex
+	// file is nil after a failed write or rotation until it is reopened by
+	// the next write
+	file   *os.File
+	size   int64
+	closed bool
+	// now returns the timestamp of an entry; it is replaced in tests
+	now func() time.Time
+}
+
+// Open opens the log file described by c for appending.
+func Open(c Config) (*Logger, error) {
+	if c.Path == "" {
+		return nil, errors.New("containerlog: path is required")
+	}
+	if _, err := ParseFormat(string(c.Format)); err != nil {
+		return nil, err
+	}
+	if c.MaxSize < 0 || c.MaxFiles < 0 {
+		return nil, errors.New("containerlog: maximum size and files must not be negative")
+	}
+	l := &Logger{config: c, now: time.Now}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	f, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = fi.Size()
+	return nil
+}
+
+// Copy writes each line read from r as an entry for stream until r returns
+// EOF or an error.  r is usually a pipe from the container process, which would
+// block or be killed by SIGPIPE if it stopped being read, so a line that cannot
+// be written is discarded and the next line is written to a reopened file.  The
+// first write error is returned once r has been read.
+func (l *Logger) Copy(stream string, r io.Reader) error {
+	br := bufio.NewReaderSize(r, maxLineSize)
+	var werr error
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			// a full buffer is written as a partial line; the rest of the
+			// line follows in later entries
+			partial := err == bufio.ErrBufferFull
+			if e := l.write(stream, line, partial); e != nil && werr == nil {
+				werr = e
+			}
+		}
+		if err == io.EOF {
+			return werr
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// write writes a single entry.  line includes the trailing newline, if any.
+func (l *Logger) write(stream string, line []byte, partial bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry, err := l.format(stream, line, partial)
+	if err != nil {
+		return err
+	}
+	if l.closed {
+		return os.ErrClosed
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	var rotateErr error
+	if l.config.MaxSize > 0 && l.size > 0 && l.size+int64(len(entry)) > l.config.MaxSize {
+		// if rotation fails, the entry is written to the reopened current
+		// file instead
+		rotateErr = l.rotate()
+		if l.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := l.file.Write(entry)
+	l.size += int64(n)
+	if err != nil {
+		l.file.Close()
+		l.file = nil
+		return err
+	}
+	return rotateErr
+}
+
+func (l *Logger) format(stream string, line []byte, partial bool) ([]byte, error) {
+	timestamp := l.now().UTC().Format(time.RFC3339Nano)
+	switch l.config.Format {
+	case FormatJSON:
+		entry, err := json.Marshal(struct {
+			Log    string `json:"log"`
+			Stream string `json:"stream"`
+			Time   string `json:"time"`
+		}{string(line), stream, timestamp})
+		if err != nil {
+			return nil, err
+		}
+		return append(entry, '\n'), nil
+	default:
+		tag := "F"
+		if partial {
+			tag = "P"
+		}
+		content := strings.TrimSuffix(string(line), "\n")
+		return []byte(timestamp + " " + stream + " " + tag + " " + content + "\n"), nil
+	}
+}
+
+// rotate renames the current file to Path.1, shifting older files, and opens a
+// new file.  The oldest file is removed.  The file at Path is reopened even if
+// rotation fails, and is only left closed if it cannot be opened.
+func (l *Logger) rotate() error {
+	err := l.file.Close()
+	l.file = nil
+	if err == nil {
+		err = l.shift()
+	}
+	if oerr := l.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// shift moves the closed log file aside, or truncates it if no rotated files are
+// kept.
+func (l *Logger) shift() error {
+	path := l.config.Path
+	if l.config.MaxFiles < 2 {
+		return os.Truncate(path, 0)
+	}
+	if err := os.Remove(rotatedName(path, l.config.MaxFiles-1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := l.config.MaxFiles - 2; i >= 1; i-- {
+		if err := os.Rename(rotatedName(path, i), rotatedName(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, rotatedName(path, 1))
+}
+
+func rotatedName(path string, i int) string {
+	return path + "." + strconv.Itoa(i)
+}
+
+// Close closes the log file.  Entries written after Close are discarded.
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ParseSize parses a size in bytes with an optional k, m, or g suffix for
+// kibibytes, mebibytes, or gibibytes, like "10m".
+func ParseSize(size string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"k": 1 << 10, "m": 1 << 20, "g": 1 << 30} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			multiplier = m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %q is too large", size)
+	}
+	return n * multiplier, nil
+}
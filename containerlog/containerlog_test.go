@@ -0,0 +1,160 @@
+package containerlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLogger(t *testing.T, c Config) *Logger {
+	dir, err := ioutil.TempDir("", "runj-containerlog")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c.Path = filepath.Join(dir, "container.log")
+	l, err := Open(c)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	l.now = func() time.Time { return time.Date(2021, 4, 1, 12, 0, 0, 500, time.UTC) }
+	return l
+}
+
+func readLog(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestCopyCRI(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI})
+	long := strings.Repeat("x", maxLineSize+10)
+	require.NoError(t, l.Copy("stdout", strings.NewReader("hello\n\nworld")))
+	require.NoError(t, l.Copy("stderr", strings.NewReader(long+"\n")))
+	assert.Equal(t, ""+
+		"2021-04-01T12:00:00.0000005Z stdout F hello\n"+
+		"2021-04-01T12:00:00.0000005Z stdout F \n"+
+		"2021-04-01T12:00:00.0000005Z stdout F world\n"+
+		"2021-04-01T12:00:00.0000005Z stderr P "+long[:maxLineSize]+"\n"+
+		"2021-04-01T12:00:00.0000005Z stderr F "+long[maxLineSize:]+"\n",
+		readLog(t, l.config.Path))
+}
+
+func TestCopyJSON(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatJSON})
+	require.NoError(t, l.Copy("stdout", strings.NewReader("hello \"world\"\nend")))
+	assert.Equal(t, ""+
+		`{"log":"hello \"world\"\n","stream":"stdout","time":"2021-04-01T12:00:00.0000005Z"}`+"\n"+
+		`{"log":"end","stream":"stdout","time":"2021-04-01T12:00:00.0000005Z"}`+"\n",
+		readLog(t, l.config.Path))
+}
+
+func TestRotate(t *testing.T) {
+	// each entry is 40 bytes, so each file holds two entries
+	l := testLogger(t, Config{Format: FormatCRI, MaxSize: 100, MaxFiles: 3})
+	for _, line := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		require.NoError(t, l.Copy("stdout", strings.NewReader(line+"\n")))
+	}
+	entries := func(path string) string {
+		var lines []string
+		for _, entry := range strings.Split(strings.TrimSpace(readLog(t, path)), "\n") {
+			lines = append(lines, entry[len(entry)-1:])
+		}
+		return strings.Join(lines, "")
+	}
+	assert.Equal(t, "g", entries(l.config.Path))
+	assert.Equal(t, "ef", entries(l.config.Path+".1"))
+	assert.Equal(t, "cd", entries(l.config.Path+".2"))
+	_, err := os.Stat(l.config.Path + ".3")
+	assert.True(t, os.IsNotExist(err), "only MaxFiles files should be kept")
+}
+
+func TestRotateTruncate(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI, MaxSize: 100, MaxFiles: 1})
+	for _, line := range []string{"a", "b", "c"} {
+		require.NoError(t, l.Copy("stdout", strings.NewReader(line+"\n")))
+	}
+	assert.Equal(t, "2021-04-01T12:00:00.0000005Z stdout F c\n", readLog(t, l.config.Path))
+	_, err := os.Stat(l.config.Path + ".1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCopyWriteError(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI})
+	// the next write fails and reopens the file
+	require.NoError(t, l.file.Close())
+	err := l.Copy("stdout", strings.NewReader("a\nb\n"))
+	assert.Error(t, err)
+	assert.Equal(t, "2021-04-01T12:00:00.0000005Z stdout F b\n", readLog(t, l.config.Path))
+}
+
+func TestCopyDrainsOnError(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI})
+	require.NoError(t, l.file.Close())
+	// the log cannot be reopened while a directory is in its place
+	require.NoError(t, os.Remove(l.config.Path))
+	require.NoError(t, os.Mkdir(l.config.Path, 0755))
+	r := strings.NewReader(strings.Repeat("discarded\n", 10000))
+	assert.Error(t, l.Copy("stdout", r))
+	assert.Equal(t, 0, r.Len(), "the reader should be drained")
+
+	require.NoError(t, os.Remove(l.config.Path))
+	require.NoError(t, l.Copy("stdout", strings.NewReader("kept\n")))
+	assert.Equal(t, "2021-04-01T12:00:00.0000005Z stdout F kept\n", readLog(t, l.config.Path))
+}
+
+func TestRotateError(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI, MaxSize: 100, MaxFiles: 2})
+	// the current file cannot be renamed over a non-empty directory
+	require.NoError(t, os.MkdirAll(filepath.Join(l.config.Path+".1", "dir"), 0755))
+	for _, line := range []string{"a", "b", "c"} {
+		err := l.Copy("stdout", strings.NewReader(line+"\n"))
+		if line == "c" {
+			assert.Error(t, err, "rotation should fail")
+		} else {
+			require.NoError(t, err)
+		}
+	}
+	require.NotNil(t, l.file, "the log should be reopened")
+	assert.Equal(t, ""+
+		"2021-04-01T12:00:00.0000005Z stdout F a\n"+
+		"2021-04-01T12:00:00.0000005Z stdout F b\n"+
+		"2021-04-01T12:00:00.0000005Z stdout F c\n",
+		readLog(t, l.config.Path))
+
+	require.NoError(t, os.RemoveAll(l.config.Path+".1"))
+	require.NoError(t, l.Copy("stdout", strings.NewReader("d\n")))
+	assert.Equal(t, "2021-04-01T12:00:00.0000005Z stdout F d\n", readLog(t, l.config.Path))
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	l := testLogger(t, Config{Format: FormatCRI})
+	require.NoError(t, l.Close())
+	r := strings.NewReader("a\nb\n")
+	assert.Error(t, l.Copy("stdout", r))
+	assert.Equal(t, 0, r.Len(), "the reader should be drained")
+	assert.Equal(t, "", readLog(t, l.config.Path))
+	assert.NoError(t, l.Close())
+}
+
+func TestParseSize(t *testing.T) {
+	for in, expected := range map[string]int64{
+		"0":    0,
+		"1024": 1024,
+		"10k":  10 << 10,
+		"10M":  10 << 20,
+		"1g":   1 << 30,
+	} {
+		size, err := ParseSize(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, expected, size, in)
+	}
+	for _, in := range []string{"", "m", "-1", "1t", "1.5m", "9999999999g"} {
+		_, err := ParseSize(in)
+		assert.Error(t, err, in)
+	}
+}
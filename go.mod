@@ -8,6 +8,7 @@ require (
 	github.com/containerd/containerd v1.5.0-rc.1.0.20210416024557-f0890f9b3a6a
 	github.com/containerd/fifo v0.0.0-20210316144830-115abcc95a1d
 	github.com/containerd/go-runc v0.0.0-20201020171139-16b287bc67d0
+	github.com/containerd/typeurl v1.0.2
 	github.com/gogo/protobuf v1.3.2
 	github.com/hashicorp/go-multierror v1.1.0 // indirect
 	github.com/opencontainers/go-digest v1.0.0
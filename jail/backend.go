@@ -0,0 +1,87 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Jail8Backend is the name of the Backend that uses jail(8) and jexec(8).
+	Jail8Backend = "jail8"
+	// NativeBackend is the name of the Backend that uses the jail_set(2)
+	// family of system calls directly.  It is only available on FreeBSD.
+	NativeBackend = "native"
+	// DefaultBackend is the Backend used unless another is requested.
+	DefaultBackend = Jail8Backend
+
+	// BackendEnv is the environment variable used to tell runj-entrypoint
+	// which Backend to use.
+	BackendEnv = "__RUNJ_JAIL_BACKEND"
+)
+
+// Backend creates, enters, and destroys jails.  runj records the Backend used
+// to create a jail in its state so that later commands use the same Backend.
+type Backend interface {
+	// Name identifies the Backend, like "jail8"
+	Name() string
+	// Create creates a persistent jail as described by the Config and returns
+	// its numeric jail ID.
+	Create(ctx context.Context, c *Config) (int, error)
+	// Destroy removes the named jail, killing any processes left inside, and
+	// releases any resources acquired by Create.
+	Destroy(ctx context.Context, name string) error
+	// Exec replaces the calling process with argv running inside the named
+	// jail.  Exec does not return unless an error occurs.
+	Exec(name string, argv []string, env []string) error
+	// IsRunning reports whether pid is still running or whether there are any
+	// processes left inside the named jail.  A pid of 0 only checks the jail.
+	IsRunning(ctx context.Context, name string, pid int) (bool, error)
+	// Kill sends a signal to pid inside the named jail, or to every process in
+	// the jail when pid is -1.
+	Kill(ctx context.Context, name string, pid int, signal unix.Signal) error
+}
+
+var (
+	backendsMu sync.Mutex
+	backends   = make(map[string]Backend)
+)
+
+// RegisterBackend makes a Backend available by its name.  Registering a second
+// Backend with the same name replaces the first.
+func RegisterBackend(b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[b.Name()] = b
+}
+
+// GetBackend returns the Backend with the given name.  An empty name selects
+// the jail8 Backend, which was the only Backend before the choice was recorded
+// in state.
+func GetBackend(name string) (Backend, error) {
+	if name == "" {
+		name = Jail8Backend
+	}
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	b, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("jail: unknown backend %q", name)
+	}
+	return b, nil
+}
+
+// Backends returns the names of the registered Backends.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -5,28 +5,72 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 
 	"go.sbk.wtf/runj/state"
 )
 
 const (
+	// DefaultDevfsRuleset is the devfs(8) ruleset applied to the jail's /dev
+	// unless another is requested.  Ruleset 4 is devfsrules_jail from the
+	// default /etc/defaults/devfs.rules.
+	DefaultDevfsRuleset = 4
+
 	confName       = "jail.conf"
 	configTemplate = `{{ .Name }} {
-  path = "{{ .Root }}";
-  devfs_ruleset = 4;
-  mount.devfs;
-  persist;
+{{- range .Parameters }}
+  {{ .Name }}{{ if .Value }} = {{ .Value }}{{ end }};
+{{- end }}
 }
 `
 )
 
-func CreateConfig(id, root string) (string, error) {
-	config, err := renderConfig(id, root)
+// Config describes a jail to be created.
+type Config struct {
+	// Name is the name of the jail, which is the container ID
+	Name string
+	// Root is the path to the jail's root filesystem
+	Root string
+	// DevfsRuleset is the devfs(8) ruleset applied to the jail's /dev
+	DevfsRuleset int
+	// Parameters are additional jail(8) parameters keyed by name.  An empty
+	// value sets a boolean parameter.  Parameters must be accepted by
+	// ValidateParameter.
+	Parameters map[string]string
+}
+
+// parameters are the jail(8) parameters runj sets for every jail, in the order
+// they are written to jail.conf(5).  A nil value marks a boolean parameter.
+var parameters = []struct {
+	name  string
+	value func(c *Config) string
+}{
+	{"path", func(c *Config) string { return quote(c.Root) }},
+	{"devfs_ruleset", func(c *Config) string { return strconv.Itoa(c.DevfsRuleset) }},
+	{"mount.devfs", nil},
+	{"persist", nil},
+}
+
+// Parameters returns the names of the jail(8) parameters runj sets for every
+// jail.
+func Parameters() []string {
+	names := make([]string, 0, len(parameters))
+	for _, p := range parameters {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+func CreateConfig(c *Config) (string, error) {
+	config, err := renderConfig(c)
 	if err != nil {
 		return "", err
 	}
-	confPath := ConfPath(id)
+	confPath := ConfPath(c.Name)
 	confFile, err := os.OpenFile(confPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 	if err != nil {
 		return "", fmt.Errorf("jail: config should not already exist: %w", err)
@@ -48,18 +92,152 @@ func ConfPath(id string) string {
 	return filepath.Join(state.Dir(id), confName)
 }
 
-func renderConfig(id, root string) (string, error) {
+func renderConfig(c *Config) (string, error) {
 	config, err := template.New("config").Parse(configTemplate)
 	if err != nil {
 		return "", err
 	}
+	type param struct {
+		Name  string
+		Value string
+	}
+	var params []param
+	for _, p := range parameters {
+		v := ""
+		if p.value != nil {
+			v = p.value(c)
+		}
+		params = append(params, param{Name: p.name, Value: v})
+	}
+	names := make([]string, 0, len(c.Parameters))
+	for name := range c.Parameters {
+		if err := ValidateParameter(name, c.Parameters[name]); err != nil {
+			return "", err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := ""
+		if c.Parameters[name] != "" {
+			v = quote(c.Parameters[name])
+		}
+		params = append(params, param{Name: name, Value: v})
+	}
 	buf := bytes.Buffer{}
-	config.Execute(&buf, struct {
-		Name string
-		Root string
+	err = config.Execute(&buf, struct {
+		Name       string
+		Parameters []param
 	}{
-		Name: id,
-		Root: root,
+		Name:       c.Name,
+		Parameters: params,
 	})
+	if err != nil {
+		return "", err
+	}
 	return buf.String(), nil
 }
+
+// quote returns s as a double-quoted jail.conf(5) string.  jail.conf expands
+// variables like $name in double-quoted strings, so $ is escaped as well.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(s) + `"`
+}
+
+// ParameterAnnotationPrefix is the prefix of config.json annotations that set
+// additional jail(8) parameters.  The rest of the annotation key is the
+// parameter name, like "org.freebsd.jail.host.hostname".
+const ParameterAnnotationPrefix = "org.freebsd.jail."
+
+// validParameterName matches the names of jail(8) parameters
+var validParameterName = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z0-9_]+)*$`)
+
+// allowedParameters are the jail(8) parameters that can be set through
+// Config.Parameters, with the values each accepts.  A nil list accepts any
+// value.  Only parameters that cannot weaken the jail's isolation from the host
+// are allowed: parameters that share the host's network, IPC, or filesystems
+// with the jail, grant privileges like raw sockets or mounting, change the
+// jail's visibility of the host, or run commands are rejected.
+var allowedParameters = map[string][]string{
+	"host.hostname":        nil,
+	"host.domainname":      nil,
+	"host.hostuuid":        nil,
+	"host.hostid":          nil,
+	"osrelease":            nil,
+	"osreldate":            nil,
+	"ip4":                  {"new", "disable"},
+	"ip4.addr":             nil,
+	"ip4.saddrsel":         nil,
+	"ip6":                  {"new", "disable"},
+	"ip6.addr":             nil,
+	"ip6.saddrsel":         nil,
+	"sysvmsg":              {"new", "disable"},
+	"sysvsem":              {"new", "disable"},
+	"sysvshm":              {"new", "disable"},
+	"allow.set_hostname":   nil,
+	"allow.reserved_ports": nil,
+}
+
+// AllowedParameters returns the names of the jail(8) parameters that can be set
+// through Config.Parameters.
+func AllowedParameters() []string {
+	names := make([]string, 0, len(allowedParameters))
+	for name := range allowedParameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AllowedValues returns the values accepted for a jail(8) parameter that can be
+// set through Config.Parameters, or nil if any value is accepted.
+func AllowedValues(name string) []string {
+	return append([]string(nil), allowedParameters[name]...)
+}
+
+// ValidateParameter checks whether a jail(8) parameter may be set through
+// Config.Parameters.
+func ValidateParameter(name, value string) error {
+	if !validParameterName.MatchString(name) {
+		return fmt.Errorf("jail: invalid parameter name %q", name)
+	}
+	for _, p := range parameters {
+		if name == p.name {
+			return fmt.Errorf("jail: parameter %q is managed by runj", name)
+		}
+	}
+	values, ok := allowedParameters[name]
+	if !ok {
+		return fmt.Errorf("jail: parameter %q cannot be set", name)
+	}
+	if values != nil && !containsString(values, value) {
+		return fmt.Errorf("jail: parameter %q must be one of %s", name, strings.Join(values, ", "))
+	}
+	for _, c := range value {
+		if c < ' ' || c == 0x7f {
+			return fmt.Errorf("jail: parameter %q has a control character in its value", name)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParametersFromAnnotations returns the jail(8) parameters set by config.json
+// annotations with ParameterAnnotationPrefix.
+func ParametersFromAnnotations(annotations map[string]string) map[string]string {
+	params := make(map[string]string)
+	for k, v := range annotations {
+		if strings.HasPrefix(k, ParameterAnnotationPrefix) {
+			params[strings.TrimPrefix(k, ParameterAnnotationPrefix)] = v
+		}
+	}
+	return params
+}
@@ -12,9 +12,89 @@ func TestRenderConfigBasic(t *testing.T) {
 		id   = "basic"
 		path = "/tmp/test/basic/root"
 	)
+	// basic.conf must list every line of the template, including the devfs
+	// settings that the template has always rendered
 	expected, err := ioutil.ReadFile("testdata/basic.conf")
 	assert.NoError(t, err, "test data")
-	actual, err := renderConfig(id, path)
+	actual, err := renderConfig(&Config{
+		Name:         id,
+		Root:         path,
+		DevfsRuleset: DefaultDevfsRuleset,
+	})
 	assert.NoError(t, err, "render")
 	assert.Equal(t, string(expected), actual)
 }
+
+func TestRenderConfigParameters(t *testing.T) {
+	actual, err := renderConfig(&Config{
+		Name:         "param",
+		Root:         "/tmp/root",
+		DevfsRuleset: DefaultDevfsRuleset,
+		Parameters: map[string]string{
+			"host.hostname":      `ex"ample`,
+			"host.domainname":    `${name}.example`,
+			"allow.set_hostname": "",
+		},
+	})
+	assert.NoError(t, err, "render")
+	expected := `param {
+  path = "/tmp/root";
+  devfs_ruleset = 4;
+  mount.devfs;
+  persist;
+  allow.set_hostname;
+  host.domainname = "\${name}.example";
+  host.hostname = "ex\"ample";
+}
+`
+	assert.Equal(t, expected, actual)
+}
+
+func TestValidateParameter(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"host.hostname", "example", true},
+		{"allow.set_hostname", "", true},
+		{"allow.reserved_ports", "false", true},
+		{"ip4", "new", true},
+		{"ip4.addr", "192.0.2.1", true},
+		{"ip6", "disable", true},
+		{"sysvshm", "new", true},
+		{"path", "/", false},
+		{"persist", "", false},
+		{"devfs_ruleset", "0", false},
+		{"Host", "", false},
+		{"host.hostname;", "", false},
+		{"host.hostname", "a\nb", false},
+		// parameters that would weaken the jail's isolation from the host
+		{"name", "other", false},
+		{"jid", "1", false},
+		{"command", "/bin/sh", false},
+		{"exec.prestart", "/bin/sh", false},
+		{"mount.fstab", "/etc/fstab", false},
+		{"ip4", "inherit", false},
+		{"ip6", "inherit", false},
+		{"sysvmsg", "inherit", false},
+		{"sysvsem", "inherit", false},
+		{"sysvshm", "inherit", false},
+		{"vnet", "new", false},
+		{"vnet.interface", "em0", false},
+		{"allow.mount", "", false},
+		{"allow.mount.devfs", "", false},
+		{"allow.mount.nullfs", "", false},
+		{"allow.raw_sockets", "", false},
+		{"allow.socket_af", "", false},
+		{"allow.chflags", "", false},
+		{"allow.read_msgbuf", "", false},
+		{"allow.sysvipc", "", false},
+		{"enforce_statfs", "0", false},
+		{"securelevel", "-1", false},
+		{"children.max", "10", false},
+	} {
+		err := ValidateParameter(tc.name, tc.value)
+		assert.Equal(t, tc.ok, err == nil, "%s=%q: %v", tc.name, tc.value, err)
+	}
+}
@@ -0,0 +1,117 @@
+package jail
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// devdSocket is the seqpacket socket where devd(8) publishes kernel
+	// notifications to its clients
+	devdSocket = "/var/run/devd.seqpacket.pipe"
+	// devdMaxMessage is the largest notification devd(8) sends in one packet
+	devdMaxMessage = 8192
+)
+
+// RctlEvent is a notification from the kernel that an rctl(8) rule with the
+// "devctl" action has been matched by a process inside a jail.
+type RctlEvent struct {
+	// Rule is the rule that matched, like "jail:foo:memoryuse:devctl=1g"
+	Rule string
+	// Resource is the resource named in the rule, like "memoryuse"
+	Resource string
+	// PID is the process that caused the rule to match
+	PID int
+	// Jail is the name of the jail containing PID
+	Jail string
+}
+
+// IsMemory reports whether the rule that matched limits memory use.
+func (e RctlEvent) IsMemory() bool {
+	switch e.Resource {
+	case "memoryuse", "vmemoryuse", "swapuse":
+		return true
+	}
+	return false
+}
+
+// SubscribeRctl connects to devd(8) and returns a channel of RctlEvents for the
+// named jail.  Only rules with the "devctl" action cause notifications, for
+// example:
+//
+//	rctl -a jail:<name>:memoryuse:devctl=512m
+//
+// The channel is closed when ctx is canceled or when the connection to devd(8)
+// is lost.
+func SubscribeRctl(ctx context.Context, jail string) (<-chan RctlEvent, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unixpacket", devdSocket)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	events := make(chan RctlEvent)
+	go func() {
+		defer close(events)
+		buf := make([]byte, devdMaxMessage)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			e, ok := parseRctlNotification(string(buf[:n]))
+			if !ok || e.Jail != jail {
+				continue
+			}
+			select {
+			case events <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// parseRctlNotification parses a devd(8) notification of the form
+//
+//	!system=RCTL subsystem=rule type=matched rule=jail:foo:memoryuse:devctl=1g pid=123 ruid=0 jail=foo
+//
+// and reports false for any other kind of message, or for a notification
+// missing the rule, pid, or jail.  Unknown fields are ignored.
+func parseRctlNotification(msg string) (RctlEvent, bool) {
+	if !strings.HasPrefix(msg, "!") {
+		return RctlEvent{}, false
+	}
+	fields := make(map[string]string)
+	for _, f := range strings.Fields(msg[1:]) {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields[parts[0]] = parts[1]
+	}
+	if fields["system"] != "RCTL" || fields["type"] != "matched" {
+		return RctlEvent{}, false
+	}
+	e := RctlEvent{
+		Rule: fields["rule"],
+		Jail: fields["jail"],
+	}
+	rule := strings.Split(e.Rule, ":")
+	if len(rule) < 3 || e.Jail == "" {
+		return RctlEvent{}, false
+	}
+	e.Resource = rule[2]
+	pid, err := strconv.Atoi(fields["pid"])
+	if err != nil {
+		return RctlEvent{}, false
+	}
+	e.PID = pid
+	return e, true
+}
@@ -0,0 +1,122 @@
+package jail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRctlNotification(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		msg   string
+		event RctlEvent
+		ok    bool
+	}{{
+		name: "memoryuse",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:memoryuse:devctl=1g pid=123 ruid=0 jail=foo\n",
+		event: RctlEvent{
+			Rule:     "jail:foo:memoryuse:devctl=1g",
+			Resource: "memoryuse",
+			PID:      123,
+			Jail:     "foo",
+		},
+		ok: true,
+	}, {
+		name: "other resource",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:maxproc:devctl=10 pid=9 ruid=0 jail=foo",
+		event: RctlEvent{
+			Rule:     "jail:foo:maxproc:devctl=10",
+			Resource: "maxproc",
+			PID:      9,
+			Jail:     "foo",
+		},
+		ok: true,
+	}, {
+		name: "extra fields",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:swapuse:devctl=1m pid=7 ruid=0 jail=foo extra=1 bare",
+		event: RctlEvent{
+			Rule:     "jail:foo:swapuse:devctl=1m",
+			Resource: "swapuse",
+			PID:      7,
+			Jail:     "foo",
+		},
+		ok: true,
+	}, {
+		name: "missing rule",
+		msg:  "!system=RCTL subsystem=rule type=matched pid=123 ruid=0 jail=foo",
+	}, {
+		name: "short rule",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo pid=123 ruid=0 jail=foo",
+	}, {
+		name: "missing pid",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:memoryuse:devctl=1g ruid=0 jail=foo",
+	}, {
+		name: "non-numeric pid",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:memoryuse:devctl=1g pid=abc ruid=0 jail=foo",
+	}, {
+		name: "missing jail",
+		msg:  "!system=RCTL subsystem=rule type=matched rule=jail:foo:memoryuse:devctl=1g pid=123 ruid=0",
+	}, {
+		name: "other type",
+		msg:  "!system=RCTL subsystem=rule type=added rule=jail:foo:memoryuse:devctl=1g pid=123 ruid=0 jail=foo",
+	}, {
+		name: "other system",
+		msg:  "!system=IFNET subsystem=em0 type=LINK_UP",
+	}, {
+		name: "attach event",
+		msg:  "+uhub0 at bus=0 sernum=\"\" on usbus0",
+	}, {
+		name: "empty",
+		msg:  "",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			event, ok := parseRctlNotification(tc.msg)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.event, event)
+		})
+	}
+}
+
+func TestParseUsage(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		out   string
+		usage map[string]uint64
+		err   bool
+	}{{
+		name:  "usage",
+		out:   "cputime=12\nmemoryuse=1048576\nmaxproc=3\n",
+		usage: map[string]uint64{"cputime": 12, "memoryuse": 1048576, "maxproc": 3},
+	}, {
+		name:  "blank lines and spaces",
+		out:   "\n  cputime=1  \n\nopenfiles=20",
+		usage: map[string]uint64{"cputime": 1, "openfiles": 20},
+	}, {
+		name:  "empty",
+		out:   "",
+		usage: map[string]uint64{},
+	}, {
+		name: "missing value",
+		out:  "cputime\n",
+		err:  true,
+	}, {
+		name: "non-numeric value",
+		out:  "cputime=abc\n",
+		err:  true,
+	}, {
+		name: "negative value",
+		out:  "cputime=-1\n",
+		err:  true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			usage, err := parseUsage([]byte(tc.out))
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.usage, usage)
+		})
+	}
+}
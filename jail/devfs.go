@@ -0,0 +1,26 @@
+package jail
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// devfsPath resolves the symlinks in the jail's root path and returns it along
+// with the path at which devfs is mounted.  The root filesystem comes from the
+// container image, so dev must be a directory directly inside the resolved root
+// and not a symlink that would have devfs mounted elsewhere on the host.
+func devfsPath(root string) (string, string, error) {
+	resolved, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", fmt.Errorf("jail: failed to resolve root: %w", err)
+	}
+	path := filepath.Join(resolved, "dev")
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", "", fmt.Errorf("jail: %s must be a directory and not a symlink: %w", path, err)
+	}
+	unix.Close(fd)
+	return resolved, path, nil
+}
@@ -0,0 +1,59 @@
+package jail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDevfsPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runj-devfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	rootfs := filepath.Join(dir, "rootfs")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "dev"), 0755))
+	require.NoError(t, os.Symlink("rootfs", filepath.Join(dir, "link")))
+
+	root, devPath, err := devfsPath(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, rootfs, root)
+	assert.Equal(t, filepath.Join(rootfs, "dev"), devPath)
+}
+
+func TestDevfsPathSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runj-devfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	host := filepath.Join(dir, "host")
+	require.NoError(t, os.MkdirAll(host, 0755))
+
+	for name, dev := range map[string]string{
+		"absolute": host,
+		"relative": "../../host",
+		"inside":   "etc",
+	} {
+		rootfs := filepath.Join(dir, name, "rootfs")
+		require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc"), 0755))
+		require.NoError(t, os.Symlink(dev, filepath.Join(rootfs, "dev")))
+		_, _, err := devfsPath(rootfs)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestDevfsPathMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runj-devfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "dev"), nil, 0644))
+
+	_, _, err = devfsPath(dir)
+	assert.Error(t, err, "dev is a file")
+	_, _, err = devfsPath(filepath.Join(dir, "missing"))
+	assert.Error(t, err, "root does not exist")
+}
@@ -2,6 +2,7 @@ package jail
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,7 +11,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/sys/unix"
@@ -23,22 +26,69 @@ const (
 	execSkipFifo     = "-"
 	consoleSocketEnv = "__RUNJ_CONSOLE_SOCKET"
 	stdioFdCount     = 3
+
+	// ConsoleSizeEnv and ConsoleRawEnv pass the Console options to
+	// runj-entrypoint.  The size is formatted as HEIGHTxWIDTH.
+	ConsoleSizeEnv = "__RUNJ_CONSOLE_SIZE"
+	ConsoleRawEnv  = "__RUNJ_CONSOLE_RAW"
+
+	// StatusFDEnv is the environment variable that tells runj-entrypoint which
+	// file descriptor is the status pipe
+	StatusFDEnv = "__RUNJ_STATUS_FD"
+
+	// ListenFDsEnv and ListenPIDEnv are the environment variables used for
+	// systemd-style socket activation, see sd_listen_fds(3)
+	ListenFDsEnv = "LISTEN_FDS"
+	ListenPIDEnv = "LISTEN_PID"
 )
 
+// Console configures the pseudoterminal runj-entrypoint allocates for a
+// process.
+type Console struct {
+	// SocketPath is the AF_UNIX socket that receives the master end of the
+	// pseudoterminal
+	SocketPath string
+	// Height and Width are the initial size of the console in characters.  The
+	// size is left unset if either is zero.
+	Height uint
+	Width  uint
+	// Raw puts the console in raw mode, see cfmakeraw(3)
+	Raw bool
+}
+
+// Entrypoint is a runj-entrypoint process started by SetupEntrypoint.
+type Entrypoint struct {
+	*exec.Cmd
+	// ConsolePath is the path of the pseudoterminal slave allocated for the
+	// process, if a Console was requested
+	ConsolePath string
+}
+
 // SetupEntrypoint starts a runj-entrypoint process, which is used to start
 // processes inside the jail.
 //
 // When used to start the jail's init process, runj-entrypoint will later be
 // signalled through `runj start` to run the specified program in the jail. This
-// indirection is necessary so that the STDIO for `runj create` or the supplied
-// console socket is directed to that process.
+// indirection is necessary so that the STDIO for `runj create` or the
+// pseudoterminal sent to the console socket is directed to that process.
 //
 // When used to start a secondary process inside the jail, the waiting step is
 // skipped and runj-entrypoint will immediately proceed to create the process
 // as soon as STDIO is configured.
 //
+// listenFDs socket activation file descriptors followed by preserveFDs
+// additional file descriptors, starting at fd 3 in the caller, are passed to
+// the process at the same numbers.  When listenFDs is non-zero, LISTEN_FDS is
+// set in the process's environment and runj-entrypoint sets LISTEN_PID to the
+// process's pid.
+//
+// SetupEntrypoint returns once runj-entrypoint has finished setting up STDIO
+// (for the init process) or has exec'd the program (for secondary processes).
+// Failures in runj-entrypoint up to that point are reported through a status
+// pipe and returned as an *EntrypointError.
+//
 // Note: this API is unstable; expect it to change.
-func SetupEntrypoint(id string, init bool, argv []string, env []string, consoleSocketPath string) (*exec.Cmd, error) {
+func SetupEntrypoint(id, backend string, init bool, argv []string, env []string, console *Console, listenFDs, preserveFDs int) (*Entrypoint, error) {
 	path := execSkipFifo
 	if init {
 		var err error
@@ -52,11 +102,19 @@ func SetupEntrypoint(id string, init bool, argv []string, env []string, consoleS
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Env = env
+	if listenFDs > 0 {
+		env = append(withoutEnv(env, ListenFDsEnv, ListenPIDEnv), ListenFDsEnv+"="+strconv.Itoa(listenFDs))
+	}
+	cmd.Env = append(env, BackendEnv+"="+backend)
+	preserved, err := PreservedFiles(listenFDs + preserveFDs)
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExtraFiles = preserved
 
 	// the caller of runj will handle receiving the console master
-	if consoleSocketPath != "" {
-		conn, err := net.Dial("unix", consoleSocketPath)
+	if console != nil {
+		conn, err := net.Dial("unix", console.SocketPath)
 		if err != nil {
 			return nil, err
 		}
@@ -72,9 +130,148 @@ func SetupEntrypoint(id string, init bool, argv []string, env []string, consoleS
 		cmd.Env = append(cmd.Env,
 			consoleSocketEnv+"="+strconv.Itoa(stdioFdCount+len(cmd.ExtraFiles)-1),
 		)
+		if console.Height > 0 && console.Width > 0 {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%dx%d", ConsoleSizeEnv, console.Height, console.Width))
+		}
+		if console.Raw {
+			cmd.Env = append(cmd.Env, ConsoleRawEnv+"=1")
+		}
+	}
+
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer statusR.Close()
+	cmd.ExtraFiles = append(cmd.ExtraFiles, statusW)
+	cmd.Env = append(cmd.Env,
+		StatusFDEnv+"="+strconv.Itoa(stdioFdCount+len(cmd.ExtraFiles)-1),
+	)
+
+	entrypoint := &Entrypoint{Cmd: cmd}
+	err = cmd.Start()
+	// the process has its own copies of the preserved file descriptors and
+	// the status pipe
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
+	if err != nil {
+		return entrypoint, err
+	}
+	status, err := readStatus(statusR)
+	if err != nil {
+		cmd.Wait()
+		return entrypoint, err
 	}
+	entrypoint.ConsolePath = status.ConsolePath
+	return entrypoint, nil
+}
+
+// EntrypointErrorKind classifies an EntrypointError.
+type EntrypointErrorKind string
+
+const (
+	// EntrypointErrorConsole means the console could not be set up
+	EntrypointErrorConsole EntrypointErrorKind = "console"
+	// EntrypointErrorNotFound means the program was not found in the jail
+	EntrypointErrorNotFound EntrypointErrorKind = "not-found"
+	// EntrypointErrorPermission means the program could not be executed
+	// because permission was denied
+	EntrypointErrorPermission EntrypointErrorKind = "permission-denied"
+	// EntrypointErrorExec is any other failure to enter the jail or exec the
+	// program
+	EntrypointErrorExec EntrypointErrorKind = "exec"
+)
+
+// EntrypointStatus is sent by runj-entrypoint through the status pipe or the
+// exec fifo.
+type EntrypointStatus struct {
+	// ConsolePath is the path of the pseudoterminal slave, if one was
+	// allocated
+	ConsolePath string `json:"consolePath,omitempty"`
+	// Error is set if runj-entrypoint failed
+	Error *EntrypointError `json:"error,omitempty"`
+}
+
+// EntrypointError is a failure reported by runj-entrypoint.
+type EntrypointError struct {
+	Kind    EntrypointErrorKind `json:"kind"`
+	Message string              `json:"message"`
+}
 
-	return cmd, cmd.Start()
+func (e *EntrypointError) Error() string {
+	return fmt.Sprintf("runj-entrypoint: %s: %s", e.Kind, e.Message)
+}
+
+// readStatus reads the status pipe until runj-entrypoint closes it.  An empty
+// status means success.
+func readStatus(r io.Reader) (*EntrypointStatus, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return &EntrypointStatus{}, nil
+	}
+	return parseStatus(data)
+}
+
+// parseStatus parses an EntrypointStatus, returning its Error if set.
+func parseStatus(data []byte) (*EntrypointStatus, error) {
+	status := &EntrypointStatus{}
+	if err := json.Unmarshal(data, status); err != nil {
+		return nil, fmt.Errorf("invalid status from runj-entrypoint %q: %w", string(data), err)
+	}
+	if status.Error != nil {
+		return status, status.Error
+	}
+	return status, nil
+}
+
+// PreservedFiles returns the calling process's file descriptors 3 through 3+n-1
+// to be passed to a child process with exec.Cmd.ExtraFiles.
+func PreservedFiles(n int) ([]*os.File, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid number of file descriptors to preserve: %d", n)
+	}
+	files := make([]*os.File, 0, n)
+	for i := 0; i < n; i++ {
+		fd := uintptr(stdioFdCount + i)
+		if _, err := unix.FcntlInt(fd, unix.F_GETFD, 0); err != nil {
+			return nil, fmt.Errorf("cannot preserve fd %d: %w", fd, err)
+		}
+		files = append(files, os.NewFile(fd, "preserved-fd-"+strconv.Itoa(int(fd))))
+	}
+	return files, nil
+}
+
+// ListenFDs returns the number of file descriptors passed to the calling
+// process through systemd-style socket activation, starting at fd 3.  It
+// returns 0 if LISTEN_PID does not match the calling process.
+func ListenFDs() int {
+	if os.Getenv(ListenPIDEnv) != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	n, err := strconv.Atoi(os.Getenv(ListenFDsEnv))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// withoutEnv returns env without the named variables.
+func withoutEnv(env []string, names ...string) []string {
+	filtered := make([]string, 0, len(env))
+outer:
+	for _, e := range env {
+		for _, name := range names {
+			if strings.HasPrefix(e, name+"=") {
+				continue outer
+			}
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
 }
 
 // CleanupEntrypoint sends a SIGTERM to the PID recorded in the state file.
@@ -114,25 +311,55 @@ func fifoPath(id string) string {
 	return filepath.Join(state.Dir(id), execFifoFilename)
 }
 
-func AwaitFifoOpen(ctx context.Context, id string) error {
-	type openResult struct {
-		file *os.File
-		err  error
-	}
-	fifoOpened := make(chan openResult)
+// entrypointPollInterval is how often AwaitFifoOpen checks that
+// runj-entrypoint is still alive
+const entrypointPollInterval = 100 * time.Millisecond
+
+// ErrEntrypointExited is returned by AwaitFifoOpen when runj-entrypoint exits
+// without opening the exec fifo, which means the container can never start.
+var ErrEntrypointExited = errors.New("runj-entrypoint exited before the container process was started")
+
+// AwaitFifoOpen unblocks runj-entrypoint for the container by opening the exec
+// fifo and waits until it has exec'd the container process.  alive is polled
+// while waiting; if it reports that runj-entrypoint is no longer running,
+// ErrEntrypointExited is returned.  An error is returned if ctx is done first.
+func AwaitFifoOpen(ctx context.Context, id string, alive func() (bool, error)) error {
+	path := fifoPath(id)
+	// buffered so that the goroutine does not leak if we stop waiting
+	fifoDone := make(chan error, 1)
 	go func() {
-		f, err := fifoOpen(fifoPath(id))
-		fifoOpened <- openResult{f, err}
-		close(fifoOpened)
+		f, err := fifoOpen(path)
+		if err != nil {
+			fifoDone <- err
+			return
+		}
+		fifoDone <- handleFifoResult(f)
 	}()
-	select {
-	case result := <-fifoOpened:
-		if result.err != nil {
-			return result.err
+	ticker := time.NewTicker(entrypointPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-fifoDone:
+			return err
+		case <-ticker.C:
+			// errors are treated as alive; ctx bounds the wait
+			ok, err := alive()
+			if err != nil || ok {
+				continue
+			}
+			// the process may have exited right after the fifo was read
+			select {
+			case err := <-fifoDone:
+				return err
+			case <-time.After(entrypointPollInterval):
+			}
+			// nothing will open the fifo for writing; remove it so that
+			// later attempts fail immediately
+			os.Remove(path)
+			return ErrEntrypointExited
+		case <-ctx.Done():
+			return fmt.Errorf("fifo: timed out waiting for runj-entrypoint: %w", ctx.Err())
 		}
-		return handleFifoResult(result.file)
-	case <-ctx.Done():
-		return errors.New("fifo: timed out")
 	}
 }
 
@@ -148,11 +375,19 @@ func fifoOpen(path string) (*os.File, error) {
 func handleFifoResult(f *os.File) error {
 	defer f.Close()
 	if err := readFromExecFifo(f); err != nil {
+		var entrypointErr *EntrypointError
+		if errors.As(err, &entrypointErr) {
+			// the container will not run, so it cannot be started again
+			os.Remove(f.Name())
+		}
 		return err
 	}
 	return os.Remove(f.Name())
 }
 
+// readFromExecFifo reads the exec fifo until runj-entrypoint closes it on exec.
+// runj-entrypoint writes a single byte once `runj start` opens the fifo,
+// followed by an EntrypointStatus if it could not exec the program.
 func readFromExecFifo(execFifo io.Reader) error {
 	data, err := ioutil.ReadAll(execFifo)
 	if err != nil {
@@ -161,6 +396,10 @@ func readFromExecFifo(execFifo io.Reader) error {
 	if len(data) <= 0 {
 		return errors.New("cannot start an already running container")
 	}
+	if len(data) > 1 {
+		_, err := parseStatus(data[1:])
+		return err
+	}
 	return nil
 }
 
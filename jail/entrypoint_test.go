@@ -0,0 +1,40 @@
+package jail
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFromExecFifo(t *testing.T) {
+	assert.NoError(t, readFromExecFifo(strings.NewReader("0")))
+	assert.Error(t, readFromExecFifo(strings.NewReader("")), "already running")
+
+	err := readFromExecFifo(strings.NewReader(`0{"error":{"kind":"not-found","message":"failed to exec: sh"}}` + "\n"))
+	entrypointErr := &EntrypointError{}
+	require.True(t, errors.As(err, &entrypointErr), "error should be an EntrypointError: %v", err)
+	assert.Equal(t, EntrypointErrorNotFound, entrypointErr.Kind)
+	assert.Equal(t, "failed to exec: sh", entrypointErr.Message)
+
+	err = readFromExecFifo(strings.NewReader("0garbage"))
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &entrypointErr))
+}
+
+func TestReadStatus(t *testing.T) {
+	status, err := readStatus(strings.NewReader(""))
+	require.NoError(t, err)
+	assert.Empty(t, status.ConsolePath)
+
+	status, err = readStatus(strings.NewReader(`{"consolePath":"/dev/pts/3"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/dev/pts/3", status.ConsolePath)
+
+	_, err = readStatus(strings.NewReader(`{"error":{"kind":"console","message":"no pty"}}`))
+	entrypointErr := &EntrypointError{}
+	require.True(t, errors.As(err, &entrypointErr), "error should be an EntrypointError: %v", err)
+	assert.Equal(t, EntrypointErrorConsole, entrypointErr.Kind)
+}
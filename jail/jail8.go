@@ -3,24 +3,88 @@ package jail
 import (
 	"context"
 	"fmt"
-	"os"
 	"os/exec"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
-func CreateJail(ctx context.Context, confPath string) error {
+const jexecPath = "/usr/sbin/jexec"
+
+func init() {
+	RegisterBackend(&jail8{})
+}
+
+// jail8 is a Backend that writes a jail.conf(5) file and manages the jail with
+// jail(8) and jexec(8).
+type jail8 struct{}
+
+func (*jail8) Name() string {
+	return Jail8Backend
+}
+
+func (*jail8) Create(ctx context.Context, c *Config) (int, error) {
+	confPath, err := CreateConfig(c)
+	if err != nil {
+		return 0, err
+	}
 	cmd := exec.CommandContext(ctx, "jail", "-cf", confPath)
-	out, err := cmd.CombinedOutput()
+	if err := runJail8(cmd); err != nil {
+		return 0, fmt.Errorf("jail: failed to create jail: %w", err)
+	}
+	return JID(ctx, c.Name)
+}
+
+func (*jail8) Destroy(ctx context.Context, name string) error {
+	cmd := exec.CommandContext(ctx, "jail", "-f", ConfPath(name), "-r", name)
+	if err := runJail8(cmd); err != nil {
+		return fmt.Errorf("jail: failed to destroy jail: %w", err)
+	}
+	return nil
+}
+
+// Exec replaces the calling process with jexec(8), which attaches to the jail
+// and execs argv.  The sequence of exec(2) calls preserves the pid.  Once
+// jexec(8) runs, a failure to exec argv is only visible as its exit status, so
+// argv[0] is first looked up in the jail's root filesystem as jexec(8) will
+// look it up.
+func (*jail8) Exec(name string, argv []string, env []string) error {
+	root, err := rootPath(context.Background(), name)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, string(out))
+		return err
+	}
+	path := defaultPath
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			path = strings.TrimPrefix(e, "PATH=")
+			break
+		}
 	}
-	return err
+	// jexec(8) runs argv in the jail's root directory
+	if _, err := LookPath(root, argv[0], "/", path); err != nil {
+		return err
+	}
+	return unix.Exec(jexecPath, append([]string{"jexec", name}, argv...), env)
+}
+
+func (*jail8) IsRunning(ctx context.Context, name string, pid int) (bool, error) {
+	return IsRunning(ctx, name, pid)
+}
+
+func (*jail8) Kill(ctx context.Context, name string, pid int, signal unix.Signal) error {
+	return Kill(ctx, name, pid, signal)
 }
 
-func DestroyJail(ctx context.Context, confPath, jail string) error {
-	cmd := exec.CommandContext(ctx, "jail", "-f", confPath, "-r", jail)
+// runJail8 runs a jail(8) command, logging its output and including the output
+// in the returned error if the command fails.
+func runJail8(cmd *exec.Cmd) error {
 	out, err := cmd.CombinedOutput()
+	entry := logrus.WithField("args", cmd.Args).WithField("output", string(out))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, string(out))
+		entry.WithError(err).Debug("jail(8) failed")
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
 	}
-	return err
+	entry.Debug("jail(8) succeeded")
+	return nil
 }
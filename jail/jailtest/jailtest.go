@@ -0,0 +1,224 @@
+/*
+Package jailtest provides a fake jail.Backend for tests.  Importing the package
+registers the Backend with the name "fake".
+
+The fake Backend does not isolate anything.  A jail is a directory under Root
+holding the jail's Config and a file for each process that entered the jail
+with Exec, which runs the program as an ordinary child process on the host.
+Because the directory is shared through the filesystem, the fake can be used
+from runj and from a runj-entrypoint built with the "fakejail" build tag.
+*/
+package jailtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.sbk.wtf/runj/jail"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// Name is the name of the fake Backend
+	Name = "fake"
+	// RootEnv is the environment variable that overrides the directory where
+	// the fake Backend keeps its jails.  runj-entrypoint only receives the
+	// container's environment, so tests should add RootEnv to process.env in
+	// config.json as well.
+	RootEnv = "RUNJ_JAILTEST_ROOT"
+
+	configFile = "config.json"
+	procsDir   = "procs"
+	jidFile    = "jid"
+)
+
+func init() {
+	jail.RegisterBackend(&Backend{})
+}
+
+// Root returns the directory where the fake Backend keeps its jails.
+func Root() string {
+	if root := os.Getenv(RootEnv); root != "" {
+		return root
+	}
+	return filepath.Join(os.TempDir(), "runj-jailtest-"+strconv.Itoa(os.Getuid()))
+}
+
+// Backend is a fake jail.Backend backed by ordinary child processes.
+type Backend struct{}
+
+var _ jail.Backend = &Backend{}
+
+func (*Backend) Name() string {
+	return Name
+}
+
+func (*Backend) Create(ctx context.Context, c *jail.Config) (int, error) {
+	for name, value := range c.Parameters {
+		if err := jail.ValidateParameter(name, value); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.MkdirAll(Root(), 0755); err != nil {
+		return 0, err
+	}
+	dir := jailDir(c.Name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return 0, fmt.Errorf("jailtest: failed to create jail: %w", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, procsDir), 0755); err != nil {
+		return 0, err
+	}
+	d, err := json.Marshal(c)
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, configFile), d, 0644); err != nil {
+		return 0, err
+	}
+	return nextJID()
+}
+
+func (b *Backend) Destroy(ctx context.Context, name string) error {
+	if _, err := Config(name); err != nil {
+		return fmt.Errorf("jailtest: failed to destroy jail: %w", err)
+	}
+	if err := b.Kill(ctx, name, -1, unix.SIGKILL); err != nil {
+		return err
+	}
+	return os.RemoveAll(jailDir(name))
+}
+
+// Exec records the calling process as a member of the jail, changes to the
+// jail's root directory, and execs argv found in PATH on the host.
+func (*Backend) Exec(name string, argv []string, env []string) error {
+	c, err := Config(name)
+	if err != nil {
+		return err
+	}
+	pid := strconv.Itoa(os.Getpid())
+	if err := ioutil.WriteFile(filepath.Join(jailDir(name), procsDir, pid), nil, 0644); err != nil {
+		return err
+	}
+	if err := os.Chdir(c.Root); err != nil {
+		return err
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, argv, env)
+}
+
+func (*Backend) IsRunning(ctx context.Context, name string, pid int) (bool, error) {
+	if pid > 0 && alive(pid) {
+		return true, nil
+	}
+	pids, err := Processes(name)
+	if err != nil {
+		return false, err
+	}
+	return len(pids) > 0, nil
+}
+
+func (*Backend) Kill(ctx context.Context, name string, pid int, signal unix.Signal) error {
+	pids, err := Processes(name)
+	if err != nil {
+		return err
+	}
+	if pid != -1 {
+		if !contains(pids, pid) {
+			return fmt.Errorf("jailtest: process %d is not in jail %q", pid, name)
+		}
+		pids = []int{pid}
+	}
+	for _, p := range pids {
+		if err := unix.Kill(p, signal); err != nil && err != unix.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// Config returns the Config the named jail was created with.
+func Config(name string) (*jail.Config, error) {
+	d, err := ioutil.ReadFile(filepath.Join(jailDir(name), configFile))
+	if err != nil {
+		return nil, err
+	}
+	c := &jail.Config{}
+	if err := json.Unmarshal(d, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Processes returns the pids of the live processes that entered the named jail.
+func Processes(name string) ([]int, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(jailDir(name), procsDir))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if alive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func jailDir(name string) string {
+	return filepath.Join(Root(), name)
+}
+
+// nextJID allocates a jail ID.  Allocation is not safe for concurrent use.
+func nextJID() (int, error) {
+	path := filepath.Join(Root(), jidFile)
+	jid := 0
+	d, err := ioutil.ReadFile(path)
+	if err == nil {
+		jid, _ = strconv.Atoi(strings.TrimSpace(string(d)))
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	jid++
+	return jid, ioutil.WriteFile(path, []byte(strconv.Itoa(jid)), 0644)
+}
+
+// alive reports whether pid exists and is not a zombie.  Zombies are only
+// detected where /proc is available.
+func alive(pid int) bool {
+	if err := unix.Kill(pid, 0); err != nil && err != unix.EPERM {
+		return false
+	}
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return true
+	}
+	// the state follows the command, which is in parentheses
+	i := strings.LastIndexByte(string(stat), ')')
+	return i < 0 || !strings.HasPrefix(string(stat[i+1:]), " Z")
+}
+
+func contains(pids []int, pid int) bool {
+	for _, p := range pids {
+		if p == pid {
+			return true
+		}
+	}
+	return false
+}
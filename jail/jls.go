@@ -0,0 +1,37 @@
+package jail
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// JID returns the numeric ID of the named jail as reported by jls(8).
+func JID(ctx context.Context, jail string) (int, error) {
+	out, err := jls(ctx, jail, "jid")
+	if err != nil {
+		return 0, err
+	}
+	jid, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, fmt.Errorf("jail: unexpected jls output %q: %w", out, err)
+	}
+	return jid, nil
+}
+
+// rootPath returns the root directory of the named jail as reported by
+// jls(8).
+func rootPath(ctx context.Context, jail string) (string, error) {
+	return jls(ctx, jail, "path")
+}
+
+// jls returns the value of a parameter of the named jail.
+func jls(ctx context.Context, jail, param string) (string, error) {
+	out, err := exec.CommandContext(ctx, "jls", "-j", jail, param).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("jail: failed to find %s: %w: %s", param, err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
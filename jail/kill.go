@@ -3,20 +3,35 @@ package jail
 import (
 	"context"
 	"fmt"
-	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 
+	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 )
 
-func Kill(ctx context.Context, jail string, pid int, signal unix.Signal) error {
-	cmd := exec.CommandContext(ctx, "jexec", jail, "kill", fmt.Sprintf("-%d", signal), strconv.Itoa(pid))
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
+// KillArg is the first argument to runj-entrypoint that makes it signal
+// processes in a jail with KillFromJail instead of starting a process.
+const KillArg = "--kill"
 
-func KillAll(ctx context.Context, jail string, signal unix.Signal) error {
-	return Kill(ctx, jail, -1, signal)
+// killAllRounds bounds the number of times SIGKILL is sent to every process in
+// the jail when a process forks while the jail is being killed.
+const killAllRounds = 10
+
+// Kill sends a signal to pid inside the jail, or to every process in the jail
+// when pid is -1.  runj-entrypoint attaches to the jail and sends the signal
+// with KillFromJail, so a pid that has exited and been reused by a process
+// outside the jail cannot be signalled.  Unlike running kill(1) through
+// jexec(8), this does not depend on anything inside the jail's root filesystem.
+func Kill(ctx context.Context, jail string, pid int, signal unix.Signal) error {
+	cmd := exec.CommandContext(ctx, "runj-entrypoint", KillArg, jail, strconv.Itoa(pid), strconv.Itoa(int(signal)))
+	out, err := cmd.CombinedOutput()
+	entry := logrus.WithField("jail", jail).WithField("pid", pid).WithField("signal", signal).WithField("output", string(out))
+	if err != nil {
+		entry.WithError(err).Debug("kill failed")
+		return fmt.Errorf("kill: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	entry.Debug("kill succeeded")
+	return nil
 }
@@ -0,0 +1,44 @@
+package jail
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// KillFromJail attaches the calling process to the named jail with
+// jail_attach(2) and sends a signal to pid, or to every process in the jail
+// when pid is -1.  Only processes in the jail are visible once attached.  The
+// calling process is left in the jail and should exit afterward.
+func KillFromJail(name string, pid int, signal unix.Signal) error {
+	jid, _, err := jailGet(name)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_JAIL_ATTACH, uintptr(jid), 0, 0); errno != 0 {
+		return fmt.Errorf("jail: failed to attach to jail: %w", errno)
+	}
+	if pid != -1 {
+		err := unix.Kill(pid, signal)
+		if err == unix.ESRCH {
+			return fmt.Errorf("process %d is not in jail %s", pid, name)
+		}
+		return err
+	}
+	// inside a jail, kill(2) with a pid of -1 signals every process in the
+	// jail except the caller, and fails with ESRCH once there are none.  A
+	// process forked while the jail is being killed is caught by sending
+	// SIGKILL again.
+	for round := 0; round < killAllRounds; round++ {
+		err := unix.Kill(-1, signal)
+		if err == unix.ESRCH {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if signal != unix.SIGKILL {
+			return nil
+		}
+	}
+	return nil
+}
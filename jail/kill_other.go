@@ -0,0 +1,15 @@
+//go:build !freebsd
+// +build !freebsd
+
+package jail
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// KillFromJail is only supported on FreeBSD.
+func KillFromJail(name string, pid int, signal unix.Signal) error {
+	return errors.New("jail: signalling from inside a jail is only supported on FreeBSD")
+}
@@ -0,0 +1,334 @@
+package jail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// jailCreate is the JAIL_CREATE flag for jail_set(2) from <sys/jail.h>
+	jailCreate = 0x01
+
+	// errmsgLen is the size of the buffer used to receive error messages from
+	// jail_set(2), jail_get(2), and nmount(2)
+	errmsgLen = 256
+
+	// unmountRetries and unmountRetryInterval bound how long Destroy waits
+	// for devfs to stop being busy after jail_remove(2)
+	unmountRetries       = 50
+	unmountRetryInterval = 100 * time.Millisecond
+)
+
+// types from <sys/sysctl.h> used to describe jail parameters
+const (
+	ctlTypeMask   = 0xf
+	ctlTypeInt    = 2
+	ctlTypeString = 3
+	ctlTypeS64    = 4
+	ctlTypeStruct = 5
+	ctlTypeUint   = 6
+	ctlTypeLong   = 7
+	ctlTypeUlong  = 8
+	ctlTypeU64    = 9
+)
+
+// values of jailsys parameters like vnet, from <sys/jail.h>
+var jailSys = map[string]int32{
+	"disable": 0,
+	"new":     1,
+	"inherit": 2,
+}
+
+func init() {
+	RegisterBackend(&native{})
+}
+
+// native is a Backend that uses the jail_set(2) family of system calls instead
+// of jail(8).  devfs is mounted in the jail with nmount(2) as jail(8) would for
+// the mount.devfs parameter.
+type native struct{}
+
+func (*native) Name() string {
+	return NativeBackend
+}
+
+func (*native) Create(ctx context.Context, c *Config) (int, error) {
+	root, devPath, err := devfsPath(c.Root)
+	if err != nil {
+		return 0, err
+	}
+	params := &iovecs{}
+	params.addString("name", c.Name)
+	params.addString("path", root)
+	params.addInt32("devfs_ruleset", int32(c.DevfsRuleset))
+	params.add("persist", nil)
+	for name, value := range c.Parameters {
+		if err := ValidateParameter(name, value); err != nil {
+			return 0, err
+		}
+		if err := params.addParameter(name, value); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := mountDevfs(devPath, c.DevfsRuleset); err != nil {
+		return 0, fmt.Errorf("jail: failed to mount devfs: %w", err)
+	}
+	jid, err := params.call(unix.SYS_JAIL_SET, jailCreate)
+	if err != nil {
+		unix.Unmount(devPath, 0)
+		return 0, fmt.Errorf("jail: failed to create jail: %w", err)
+	}
+	logrus.WithField("jail", c.Name).WithField("jid", jid).Debug("jail_set(2) succeeded")
+	return jid, nil
+}
+
+func (*native) Destroy(ctx context.Context, name string) error {
+	jid, root, err := jailGet(name)
+	if err != nil {
+		return fmt.Errorf("jail: failed to destroy jail: %w", err)
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_JAIL_REMOVE, uintptr(jid), 0, 0); errno != 0 {
+		return fmt.Errorf("jail: failed to destroy jail: %w", errno)
+	}
+	if err := unmountDevfs(ctx, filepath.Join(root, "dev")); err != nil {
+		return fmt.Errorf("jail: failed to unmount devfs: %w", err)
+	}
+	logrus.WithField("jail", name).WithField("jid", jid).Debug("jail_remove(2) succeeded")
+	return nil
+}
+
+// unmountDevfs unmounts the devfs at devPath.  jail_remove(2) returns before
+// the jail's processes have exited, and they keep devfs busy until they do, so
+// EBUSY is retried.
+func unmountDevfs(ctx context.Context, devPath string) error {
+	for i := 0; ; i++ {
+		err := unix.Unmount(devPath, 0)
+		switch {
+		case err == nil, err == unix.EINVAL:
+			// EINVAL means devfs was not mounted
+			return nil
+		case err != unix.EBUSY || i == unmountRetries:
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(unmountRetryInterval):
+		}
+	}
+}
+
+// Exec attaches the calling process to the jail with jail_attach(2) and execs
+// argv, looking it up in PATH inside the jail as jexec(8) does.
+func (*native) Exec(name string, argv []string, env []string) error {
+	jid, _, err := jailGet(name)
+	if err != nil {
+		return err
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_JAIL_ATTACH, uintptr(jid), 0, 0); errno != 0 {
+		return fmt.Errorf("jail: failed to attach to jail: %w", errno)
+	}
+	if err := unix.Chdir("/"); err != nil {
+		return err
+	}
+	if os.Getenv("PATH") == "" {
+		os.Setenv("PATH", defaultPath)
+	}
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	return unix.Exec(path, argv, env)
+}
+
+func (*native) IsRunning(ctx context.Context, name string, pid int) (bool, error) {
+	return IsRunning(ctx, name, pid)
+}
+
+func (*native) Kill(ctx context.Context, name string, pid int, signal unix.Signal) error {
+	return Kill(ctx, name, pid, signal)
+}
+
+// jailGet returns the jail ID and root path of the named jail.
+func jailGet(name string) (int, string, error) {
+	params := &iovecs{}
+	params.addString("name", name)
+	path := make([]byte, unix.PathMax)
+	params.add("path", path)
+	jid, err := params.call(unix.SYS_JAIL_GET, 0)
+	if err != nil {
+		return 0, "", fmt.Errorf("jail: failed to find jail %q: %w", name, err)
+	}
+	return jid, cString(path), nil
+}
+
+// mountDevfs mounts devfs at path with the devfs(8) ruleset applied.
+func mountDevfs(path string, ruleset int) error {
+	params := &iovecs{}
+	params.addString("fstype", "devfs")
+	params.addString("fspath", path)
+	params.addString("from", "devfs")
+	params.addString("ruleset", strconv.Itoa(ruleset))
+	_, err := params.call(unix.SYS_NMOUNT, 0)
+	return err
+}
+
+// iovecs are the name and value pairs used as arguments to jail_set(2),
+// jail_get(2), and nmount(2).
+type iovecs struct {
+	iov []unix.Iovec
+}
+
+// add appends a name and value.  A nil value is used for boolean parameters
+// and for parameters that jail_get(2) fills in.
+func (p *iovecs) add(name string, value []byte) {
+	p.iov = append(p.iov, iovec(append([]byte(name), 0)), iovec(value))
+}
+
+func (p *iovecs) addString(name, value string) {
+	p.add(name, append([]byte(value), 0))
+}
+
+func (p *iovecs) addInt32(name string, value int32) {
+	b := make([]byte, 4)
+	*(*int32)(unsafe.Pointer(&b[0])) = value
+	p.add(name, b)
+}
+
+// addParameter appends a jail parameter from a Config, converting the value to
+// the type the kernel expects.  An empty value sets a boolean parameter.
+func (p *iovecs) addParameter(name, value string) error {
+	if value == "" {
+		p.add(name, nil)
+		return nil
+	}
+	kind, format, err := sysctlFormat("security.jail.param." + name)
+	if err != nil {
+		return fmt.Errorf("jail: unknown parameter %q: %w", name, err)
+	}
+	switch {
+	case kind&ctlTypeMask == ctlTypeString:
+		p.addString(name, value)
+	case format == "B":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("jail: parameter %q: %w", name, err)
+		}
+		if !b {
+			// booleans are cleared by prefixing the last component with "no"
+			i := strings.LastIndexByte(name, '.') + 1
+			name = name[:i] + "no" + name[i:]
+		}
+		p.add(name, nil)
+	case format == "E":
+		v, ok := jailSys[value]
+		if !ok {
+			return fmt.Errorf("jail: parameter %q must be one of disable, new, or inherit", name)
+		}
+		p.addInt32(name, v)
+	case kind&ctlTypeMask == ctlTypeInt || kind&ctlTypeMask == ctlTypeUint:
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return fmt.Errorf("jail: parameter %q: %w", name, err)
+		}
+		p.addInt32(name, int32(v))
+	case kind&ctlTypeMask == ctlTypeLong || kind&ctlTypeMask == ctlTypeUlong ||
+		kind&ctlTypeMask == ctlTypeS64 || kind&ctlTypeMask == ctlTypeU64:
+		v, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return fmt.Errorf("jail: parameter %q: %w", name, err)
+		}
+		b := make([]byte, 8)
+		*(*int64)(unsafe.Pointer(&b[0])) = v
+		p.add(name, b)
+	case kind&ctlTypeMask == ctlTypeStruct && (format == "S,in_addr" || format == "S,in6_addr"):
+		var b []byte
+		for _, s := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return fmt.Errorf("jail: parameter %q: invalid address %q", name, s)
+			}
+			if format == "S,in_addr" {
+				if ip = ip.To4(); ip == nil {
+					return fmt.Errorf("jail: parameter %q: %q is not an IPv4 address", name, s)
+				}
+			}
+			b = append(b, ip...)
+		}
+		p.add(name, b)
+	default:
+		return fmt.Errorf("jail: parameter %q has unsupported type %q; use the %s backend", name, format, Jail8Backend)
+	}
+	return nil
+}
+
+// call invokes jail_set(2), jail_get(2), or nmount(2) with the iovecs.  The
+// kernel's error message, if any, is included in the returned error.
+func (p *iovecs) call(trap uintptr, flags int) (int, error) {
+	errmsg := make([]byte, errmsgLen)
+	p.add("errmsg", errmsg)
+	r, _, errno := unix.Syscall(trap, uintptr(unsafe.Pointer(&p.iov[0])), uintptr(len(p.iov)), uintptr(flags))
+	if errno != 0 {
+		if msg := cString(errmsg); msg != "" {
+			return -1, fmt.Errorf("%s: %w", msg, errno)
+		}
+		return -1, errno
+	}
+	return int(r), nil
+}
+
+func iovec(b []byte) unix.Iovec {
+	var iov unix.Iovec
+	if len(b) > 0 {
+		iov.Base = &b[0]
+		iov.SetLen(len(b))
+	}
+	return iov
+}
+
+// sysctlFormat returns the kind and format string of a sysctl(3) node, like
+// CTLTYPE_INT and "I".
+func sysctlFormat(name string) (uint32, string, error) {
+	// {0, 3} is the undocumented name2oid node and {0, 4} is oidfmt, which are
+	// used by sysctl(8) and libjail
+	oid := make([]int32, 24)
+	n := uintptr(len(oid) * 4)
+	nameb := []byte(name)
+	if err := sysctl([]int32{0, 3}, (*byte)(unsafe.Pointer(&oid[0])), &n, &nameb[0], uintptr(len(nameb))); err != nil {
+		return 0, "", err
+	}
+	oid = oid[:n/4]
+	buf := make([]byte, 256)
+	n = uintptr(len(buf))
+	if err := sysctl(append([]int32{0, 4}, oid...), &buf[0], &n, nil, 0); err != nil {
+		return 0, "", err
+	}
+	if n < 4 {
+		return 0, "", errors.New("short oidfmt")
+	}
+	return *(*uint32)(unsafe.Pointer(&buf[0])), cString(buf[4:n]), nil
+}
+
+func sysctl(mib []int32, old *byte, oldlen *uintptr, new *byte, newlen uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS___SYSCTL,
+		uintptr(unsafe.Pointer(&mib[0])), uintptr(len(mib)),
+		uintptr(unsafe.Pointer(old)), uintptr(unsafe.Pointer(oldlen)),
+		uintptr(unsafe.Pointer(new)), newlen)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package jail
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Process describes a process inside a jail.
+type Process struct {
+	PID  int `json:"pid"`
+	PPID int `json:"ppid"`
+	UID  int `json:"uid"`
+	// State is the first letter of the state reported by ps(1), like "R" for
+	// runnable, "S" for sleeping, or "Z" for a zombie
+	State string `json:"state"`
+	// RSS is the resident set size in bytes
+	RSS uint64 `json:"rss"`
+	// CPUTime is the user and system time used by the process
+	CPUTime time.Duration `json:"cpuTime"`
+	// Command is the name of the executable
+	Command string `json:"command"`
+}
+
+// Processes returns the processes in the jail.  On FreeBSD the processes are
+// read from the kern.proc sysctl(3); the host's "ps" command is used where the
+// layout of struct kinfo_proc is not known.
+func Processes(ctx context.Context, jail string) ([]Process, error) {
+	procs, err := sysctlProcesses(jail)
+	if errors.Is(err, errKinfoProcUnsupported) {
+		return psProcesses(ctx, jail)
+	}
+	return procs, err
+}
+
+// IsRunning attempts to determine whether a given jail is running.  This is
+// accomplished by looking to see whether the jail's primary pid (passed as an
+// argument) is still active and by whether there are any processes present in
+// the jail.  The primary pid is checked outside of the jail too, as
+// runj-entrypoint only enters the jail when the container is started.  This
+// function is best-effort, racy, and subject to change.
+func IsRunning(ctx context.Context, jail string, pid int) (bool, error) {
+	if pid > 0 {
+		if err := unix.Kill(pid, 0); err == nil || err == unix.EPERM {
+			// if the primary pid is present, we're done
+			return true, nil
+		}
+	}
+	procs, err := Processes(ctx, jail)
+	if err != nil {
+		return false, err
+	}
+	return len(procs) > 0, nil
+}
+
+// errKinfoProcUnsupported is returned when the kern.proc sysctl(3) cannot be
+// decoded on this platform
+var errKinfoProcUnsupported = errors.New("kinfo_proc: unsupported platform")
+
+// offsets of the fields of struct kinfo_proc from <sys/user.h> on LP64
+// platforms (amd64, arm64, powerpc64, and riscv64)
+const (
+	kinfoProcSize      = 1088
+	kinfoOffStructSize = 0
+	kinfoOffPID        = 72
+	kinfoOffPPID       = 76
+	kinfoOffUID        = 168
+	kinfoOffRSSize     = 264
+	kinfoOffRuntime    = 328
+	kinfoOffStat       = 388
+	kinfoOffComm       = 447
+	kinfoCommLen       = 20
+	kinfoOffJID        = 592
+)
+
+// kinfoStates maps ki_stat to the letters used by ps(1).  Sleeping processes
+// are reported as "S" as the flags ps(1) uses to tell "D" and "I" apart are
+// not decoded.
+var kinfoStates = map[byte]string{
+	1: "R", // SIDL
+	2: "R", // SRUN
+	3: "S", // SSLEEP
+	4: "T", // SSTOP
+	5: "Z", // SZOMB
+	6: "W", // SWAIT
+	7: "L", // SLOCK
+}
+
+// parseKinfoProc decodes an array of struct kinfo_proc in the LP64 layout with
+// the given byte order, returning the processes in the jail with ID jid.
+func parseKinfoProc(buf []byte, order binary.ByteOrder, pageSize int, jid int) ([]Process, error) {
+	var procs []Process
+	for len(buf) > 0 {
+		if len(buf) < kinfoProcSize {
+			return nil, fmt.Errorf("kinfo_proc: short record of %d bytes", len(buf))
+		}
+		if size := int32(order.Uint32(buf[kinfoOffStructSize:])); size != kinfoProcSize {
+			return nil, fmt.Errorf("%w: ki_structsize is %d", errKinfoProcUnsupported, size)
+		}
+		rec := buf[:kinfoProcSize]
+		buf = buf[kinfoProcSize:]
+		if int(int32(order.Uint32(rec[kinfoOffJID:]))) != jid {
+			continue
+		}
+		state, ok := kinfoStates[rec[kinfoOffStat]]
+		if !ok {
+			state = "?"
+		}
+		procs = append(procs, Process{
+			PID:     int(int32(order.Uint32(rec[kinfoOffPID:]))),
+			PPID:    int(int32(order.Uint32(rec[kinfoOffPPID:]))),
+			UID:     int(order.Uint32(rec[kinfoOffUID:])),
+			State:   state,
+			RSS:     order.Uint64(rec[kinfoOffRSSize:]) * uint64(pageSize),
+			CPUTime: time.Duration(order.Uint64(rec[kinfoOffRuntime:])) * time.Microsecond,
+			Command: cString(rec[kinfoOffComm : kinfoOffComm+kinfoCommLen]),
+		})
+	}
+	return procs, nil
+}
+
+// cString returns the NUL-terminated string at the start of b.
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,36 @@
+package jail
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysctlProcesses reads the processes in the jail from the kern.proc.proc
+// sysctl(3), which returns one struct kinfo_proc for each process.
+func sysctlProcesses(jail string) ([]Process, error) {
+	switch runtime.GOARCH {
+	case "amd64", "arm64":
+	default:
+		return nil, errKinfoProcUnsupported
+	}
+	jid, _, err := jailGet(jail)
+	if errors.Is(err, unix.ENOENT) {
+		// a jail that does not exist has no processes
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	buf, err := unix.SysctlRaw("kern.proc.proc")
+	if err == unix.ENOMEM {
+		// processes were created between sizing the buffer and reading it
+		buf, err = unix.SysctlRaw("kern.proc.proc")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseKinfoProc(buf, binary.LittleEndian, os.Getpagesize(), jid)
+}
@@ -0,0 +1,8 @@
+//go:build !freebsd
+// +build !freebsd
+
+package jail
+
+func sysctlProcesses(jail string) ([]Process, error) {
+	return nil, errKinfoProcUnsupported
+}
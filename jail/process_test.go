@@ -0,0 +1,49 @@
+package jail
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expectedProcesses are the processes of jail 7 in testdata/kinfo_proc.bin and
+// testdata/ps.json
+var expectedProcesses = []Process{
+	{PID: 4321, PPID: 4300, UID: 0, State: "S", RSS: 1024 * 1024, CPUTime: 1500 * time.Millisecond, Command: "sh"},
+	{PID: 4322, PPID: 4321, UID: 65534, State: "R", RSS: 2048 * 1024, CPUTime: 20 * time.Millisecond, Command: "sleep"},
+}
+
+func TestParseKinfoProc(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/kinfo_proc.bin")
+	assert.NoError(t, err, "test data")
+	procs, err := parseKinfoProc(data, binary.LittleEndian, 4096, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedProcesses, procs)
+
+	procs, err = parseKinfoProc(data, binary.LittleEndian, 4096, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, []Process{{PID: 4400, PPID: 1, State: "Z", RSS: 10 * 4096, Command: "sh"}}, procs)
+
+	_, err = parseKinfoProc(data[:100], binary.LittleEndian, 4096, 7)
+	assert.Error(t, err, "short record")
+
+	_, err = parseKinfoProc(data, binary.BigEndian, 4096, 7)
+	assert.True(t, errors.Is(err, errKinfoProcUnsupported))
+}
+
+func TestParsePS(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/ps.json")
+	assert.NoError(t, err, "test data")
+	procs, err := parsePS(data)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedProcesses, procs)
+
+	// output of the "ps" invocation used by earlier versions of runj
+	procs, err = parsePS([]byte(`{"process-information": {"process": [{"pid": "42", "terminal-name": "- ", "state": "Is", "cpu-time": "0:00.01", "command": "/bin/sh"}]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Process{{PID: 42, State: "I", CPUTime: 10 * time.Millisecond, Command: "/bin/sh"}}, procs)
+}
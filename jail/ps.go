@@ -4,56 +4,52 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"os"
+	"fmt"
+	"math"
 	"os/exec"
 	"strconv"
-)
-
-// IsRunning attempts to determine whether a given jail is running.  This is
-// accomplished by looking to see whether the jail's primary pid (passed as an
-// argument) is still active and by whether there are any processes present in
-// the jail.  This function is best-effort, racy, and subject to change.  It
-// currently depends on the host's "ps" command.
-func IsRunning(ctx context.Context, jail string, pid int) (bool, error) {
-	if pid > 0 {
-		if ok, err := psCmd(exec.CommandContext(ctx, "ps", "--libxo", "json", "-x", strconv.Itoa(pid))); err != nil {
-			return false, err
-		} else if ok {
-			// if the primary pid is present, we're done
-			return true, nil
-		}
-	}
+	"strings"
+	"time"
 
-	if ok, err := psCmd(exec.CommandContext(ctx, "ps", "--libxo", "json", "-x", "-J", jail)); err != nil {
-		return false, err
-	} else {
-		return ok, nil
-	}
-}
+	"github.com/sirupsen/logrus"
+)
 
-// psCmd executes a "ps" command provided as an *exec.Cmd and output with libxo
-// json and parses the result to determine whether any processes are running.
-func psCmd(cmd *exec.Cmd) (bool, error) {
-	cmd.Stderr = os.Stderr
+// psProcesses lists the processes in the jail with the host's "ps" command.
+func psProcesses(ctx context.Context, jail string) ([]Process, error) {
+	cmd := exec.CommandContext(ctx, "ps", "--libxo", "json", "-ax", "-J", jail, "-o", "pid,ppid,uid,state,rss,time,comm")
 	out, err := cmd.Output()
 	if err != nil {
 		// `ps` exits with 1 when there are no processes, which is a valid state
 		if ee, ok := err.(*exec.ExitError); ok {
 			if ee.ProcessState.ExitCode() == 1 {
-				return false, nil
+				return nil, nil
 			}
+			logrus.WithField("args", cmd.Args).WithField("stderr", string(ee.Stderr)).WithError(err).Debug("ps failed")
+			return nil, fmt.Errorf("ps: %w: %s", err, strings.TrimSpace(string(ee.Stderr)))
 		}
-		return false, err
+		return nil, err
 	}
+	return parsePS(out)
+}
+
+// parsePS decodes the libxo JSON output of ps(1).
+func parsePS(out []byte) ([]Process, error) {
 	result := &psOutput{}
-	err = json.Unmarshal(out, result)
-	if err != nil {
-		return false, err
+	if err := json.Unmarshal(out, result); err != nil {
+		return nil, err
 	}
-	if result == nil || result.ProcessInformation == nil {
-		return false, errors.New("nil result")
+	if result.ProcessInformation == nil {
+		return nil, errors.New("nil result")
+	}
+	procs := make([]Process, 0, len(result.ProcessInformation.Processes))
+	for _, p := range result.ProcessInformation.Processes {
+		proc, err := p.process()
+		if err != nil {
+			return nil, fmt.Errorf("ps: %w", err)
+		}
+		procs = append(procs, proc)
 	}
-	return len(result.ProcessInformation.Processes) > 0, nil
+	return procs, nil
 }
 
 type psOutput struct {
@@ -66,8 +62,66 @@ type psInfo struct {
 
 type psProcess struct {
 	PID          string `json:"pid"`
+	PPID         string `json:"ppid"`
+	UID          string `json:"uid"`
 	TerminalName string `json:"terminal-name"`
 	State        string `json:"state"`
+	RSS          string `json:"rss"`
 	CPUTime      string `json:"cpu-time"`
 	Command      string `json:"command"`
 }
+
+// process converts the fields of ps(1) output to a Process.  Fields that were
+// not requested from ps(1) are left as zero values.
+func (p psProcess) process() (Process, error) {
+	proc := Process{Command: strings.TrimSpace(p.Command)}
+	var err error
+	if proc.PID, err = strconv.Atoi(strings.TrimSpace(p.PID)); err != nil {
+		return proc, fmt.Errorf("invalid pid %q", p.PID)
+	}
+	if p.PPID != "" {
+		if proc.PPID, err = strconv.Atoi(strings.TrimSpace(p.PPID)); err != nil {
+			return proc, fmt.Errorf("invalid ppid %q", p.PPID)
+		}
+	}
+	if p.UID != "" {
+		if proc.UID, err = strconv.Atoi(strings.TrimSpace(p.UID)); err != nil {
+			return proc, fmt.Errorf("invalid uid %q", p.UID)
+		}
+	}
+	if state := strings.TrimSpace(p.State); state != "" {
+		proc.State = state[:1]
+	}
+	if p.RSS != "" {
+		// ps(1) reports the resident set size in kilobytes
+		kb, err := strconv.ParseUint(strings.TrimSpace(p.RSS), 10, 64)
+		if err != nil {
+			return proc, fmt.Errorf("invalid rss %q", p.RSS)
+		}
+		proc.RSS = kb * 1024
+	}
+	if p.CPUTime != "" {
+		if proc.CPUTime, err = parseCPUTime(strings.TrimSpace(p.CPUTime)); err != nil {
+			return proc, err
+		}
+	}
+	return proc, nil
+}
+
+// parseCPUTime parses a ps(1) cpu time like "1:02.50", which is minutes and
+// seconds with hundredths.
+func parseCPUTime(s string) (time.Duration, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid cpu time %q", s)
+	}
+	minutes, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu time %q", s)
+	}
+	seconds, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu time %q", s)
+	}
+	return time.Duration(minutes)*time.Minute + time.Duration(math.Round(seconds*100))*(time.Second/100), nil
+}
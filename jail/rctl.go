@@ -0,0 +1,46 @@
+package jail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Usage returns the resource usage of a jail as reported by rctl(8).  The keys
+// of the returned map are the rctl(8) resource names, like "memoryuse" or
+// "maxproc".  Usage requires RACCT to be enabled in the kernel
+// (kern.racct.enable=1).
+func Usage(ctx context.Context, jail string) (map[string]uint64, error) {
+	cmd := exec.CommandContext(ctx, "rctl", "-u", "jail:"+jail)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("rctl: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return parseUsage(out)
+}
+
+// parseUsage parses the resource=value lines output by `rctl -u`.
+func parseUsage(out []byte) (map[string]uint64, error) {
+	usage := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rctl: malformed usage line %q", line)
+		}
+		v, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rctl: malformed usage value %q: %w", line, err)
+		}
+		usage[parts[0]] = v
+	}
+	return usage, scanner.Err()
+}
@@ -0,0 +1,112 @@
+package jail
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	// maxSymlinks is the number of symlinks followed when resolving a path
+	// before failing with ELOOP, like MAXSYMLINKS in <sys/param.h>
+	maxSymlinks = 32
+
+	// defaultPath is the search path used when the environment has no PATH,
+	// like _PATH_DEFPATH used by execvp(3)
+	defaultPath = "/usr/bin:/bin"
+)
+
+// ExecutableNotFoundError is returned by LookPath when an executable cannot be
+// found in the root filesystem.  It matches exec.ErrNotFound with errors.Is.
+type ExecutableNotFoundError struct {
+	// Name is the name that was looked up, like process.args[0]
+	Name string
+	// Searched are the paths inside the jail that were tried
+	Searched []string
+}
+
+func (e *ExecutableNotFoundError) Error() string {
+	return fmt.Sprintf("executable not found: %q (searched %s)", e.Name, strings.Join(e.Searched, ", "))
+}
+
+func (e *ExecutableNotFoundError) Unwrap() error {
+	return exec.ErrNotFound
+}
+
+// LookPath finds the executable name in the root filesystem at root like
+// execvp(3) would inside the jail, searching the directories in path when name
+// does not contain a slash.  Relative paths are relative to cwd.  Symlinks are
+// followed relative to root.  The returned path is the path inside the jail.
+func LookPath(root, name, cwd, path string) (string, error) {
+	var candidates []string
+	if strings.Contains(name, "/") {
+		candidates = []string{name}
+	} else {
+		for _, dir := range filepath.SplitList(path) {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	err := &ExecutableNotFoundError{Name: name}
+	for _, c := range candidates {
+		if !filepath.IsAbs(c) {
+			c = filepath.Join(cwd, c)
+		}
+		err.Searched = append(err.Searched, c)
+		resolved, rerr := resolveInRoot(root, c)
+		if rerr != nil {
+			continue
+		}
+		if fi, serr := os.Stat(resolved); serr == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return c, nil
+		}
+	}
+	return "", err
+}
+
+// resolveInRoot returns the path on the host of path inside the jail rooted at
+// root.  Symlinks are followed as they would be inside the jail: absolute
+// targets and ".." components are resolved against root and cannot escape it.
+func resolveInRoot(root, path string) (string, error) {
+	resolved := "/"
+	rest := path
+	links := 0
+	for rest != "" {
+		part := rest
+		rest = ""
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			part, rest = part[:i], part[i+1:]
+		}
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{Op: "resolve", Path: path, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = target + "/" + rest
+	}
+	return filepath.Join(root, resolved), nil
+}
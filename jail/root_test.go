@@ -0,0 +1,31 @@
+package jail
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookPathNotFound(t *testing.T) {
+	root, err := ioutil.TempDir("", "runj-lookpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "bin/sh"), nil, 0755))
+
+	path, err := LookPath(root, "sh", "/", defaultPath)
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/sh", path)
+
+	_, err = LookPath(root, "missing", "/", defaultPath)
+	assert.True(t, errors.Is(err, exec.ErrNotFound), "the entrypoint reports a missing executable as not found: %v", err)
+	notFound := &ExecutableNotFoundError{}
+	require.True(t, errors.As(err, &notFound))
+	assert.Equal(t, []string{"/usr/bin/missing", "/bin/missing"}, notFound.Searched)
+}
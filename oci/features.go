@@ -0,0 +1,127 @@
+package oci
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/runtimespec"
+)
+
+// MinimumVersion is the oldest version of the OCI runtime specification that
+// runj accepts in config.json.
+const MinimumVersion = "1.0.0"
+
+// MaximumVersion is the newest version of the OCI runtime specification that
+// runj was written against.  Minor and patch releases of the spec only add
+// fields, so any version with the same major version is accepted.
+var MaximumVersion = fmt.Sprintf("%d.%d.%d", runtimespec.VersionMajor, runtimespec.VersionMinor, runtimespec.VersionPatch)
+
+// annotations are the config.json annotations that change runj's behavior,
+// with a function that checks each value.  The annotations that set jail(8)
+// parameters are described by jail.AllowedParameters instead.
+var annotations = []struct {
+	key      string
+	validate func(value string) error
+}{
+	{ConfigModeAnnotation, func(value string) error {
+		_, err := ParseConfigMode(value)
+		return err
+	}},
+	{ConsoleRawAnnotation, func(value string) error {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%q must be true or false", value)
+		}
+		return nil
+	}},
+}
+
+// SupportedAnnotations returns the config.json annotations recognized by runj,
+// including one for each jail(8) parameter that can be set.
+func SupportedAnnotations() []string {
+	keys := make([]string, 0, len(annotations))
+	for _, a := range annotations {
+		keys = append(keys, a.key)
+	}
+	for _, name := range jail.AllowedParameters() {
+		keys = append(keys, jail.ParameterAnnotationPrefix+name)
+	}
+	return keys
+}
+
+// supportedHooks returns the names of the hooks in config.json that runj runs,
+// which are the hooks honoured as supported fields.
+func supportedHooks() []string {
+	hooks := []string{}
+	for _, path := range SupportedFields() {
+		if strings.HasPrefix(path, "hooks.") {
+			hooks = append(hooks, strings.TrimPrefix(path, "hooks."))
+		}
+	}
+	return hooks
+}
+
+// mountTypes returns the filesystem types runj mounts in every jail, which are
+// set by the mount.* parameters in jail.Parameters.
+func mountTypes() []string {
+	types := []string{}
+	for _, name := range jail.Parameters() {
+		if strings.HasPrefix(name, "mount.") {
+			types = append(types, strings.TrimPrefix(name, "mount."))
+		}
+	}
+	return types
+}
+
+// Features describes the features supported by runj, in the format of the OCI
+// runtime features document with an additional FreeBSD section.
+type Features struct {
+	// OCIVersionMin is the minimum OCI runtime spec version recognized by
+	// runj
+	OCIVersionMin string `json:"ociVersionMin,omitempty"`
+	// OCIVersionMax is the maximum OCI runtime spec version recognized by
+	// runj
+	OCIVersionMax string `json:"ociVersionMax,omitempty"`
+	// Hooks is the list of the recognized hook names
+	Hooks []string `json:"hooks"`
+	// MountOptions is the list of the recognized mount options.  It is empty
+	// as the mounts in config.json are not supported.
+	MountOptions []string `json:"mountOptions"`
+	// FreeBSD is specific to FreeBSD jails
+	FreeBSD *FreeBSDFeatures `json:"freebsd,omitempty"`
+}
+
+// FreeBSDFeatures describes runj's FreeBSD-specific features.
+type FreeBSDFeatures struct {
+	// JailParameters is the list of jail(8) parameters runj sets for every
+	// jail
+	JailParameters []string `json:"jailParameters"`
+	// DevfsRuleset is the devfs(8) ruleset applied to jails by default
+	DevfsRuleset int `json:"devfsRuleset"`
+	// MountTypes is the list of filesystem types runj mounts in every jail.
+	// The mounts in config.json are not supported.
+	MountTypes []string `json:"mountTypes"`
+	// Annotations is the list of config.json annotations recognized by runj
+	Annotations []string `json:"annotations"`
+	// ConfigFields is the list of config.json fields honoured by runj, as
+	// JSON paths
+	ConfigFields []string `json:"configFields"`
+}
+
+// SupportedFeatures returns the features supported by runj.
+func SupportedFeatures() *Features {
+	return &Features{
+		OCIVersionMin: MinimumVersion,
+		OCIVersionMax: MaximumVersion,
+		Hooks:         supportedHooks(),
+		MountOptions:  []string{},
+		FreeBSD: &FreeBSDFeatures{
+			JailParameters: jail.Parameters(),
+			DevfsRuleset:   jail.DefaultDevfsRuleset,
+			MountTypes:     mountTypes(),
+			Annotations:    SupportedAnnotations(),
+			ConfigFields:   SupportedFields(),
+		},
+	}
+}
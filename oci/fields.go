@@ -0,0 +1,155 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigMode controls how runj handles fields of config.json that it cannot
+// honour.
+type ConfigMode string
+
+const (
+	// ConfigModePermissive silently ignores unsupported fields
+	ConfigModePermissive ConfigMode = "permissive"
+	// ConfigModeWarn logs a warning listing the unsupported fields
+	ConfigModeWarn ConfigMode = "warn"
+	// ConfigModeStrict refuses to create a container with unsupported fields
+	ConfigModeStrict ConfigMode = "strict"
+
+	// ConfigModeAnnotation is the config.json annotation that selects the
+	// ConfigMode for a container
+	ConfigModeAnnotation = "wtf.sbk.runj.config-mode"
+)
+
+// ParseConfigMode parses the name of a ConfigMode.
+func ParseConfigMode(mode string) (ConfigMode, error) {
+	switch m := ConfigMode(mode); m {
+	case ConfigModePermissive, ConfigModeWarn, ConfigModeStrict:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown config mode %q (expected %q, %q, or %q)", mode, ConfigModePermissive, ConfigModeWarn, ConfigModeStrict)
+}
+
+// supportedFields lists the fields of config.json that runj honours, as JSON
+// paths.  A field's children are honoured along with it.  When honoured is set,
+// the field is only honoured for the values it accepts.
+var supportedFields = []struct {
+	path     string
+	honoured func(value json.RawMessage) bool
+}{
+	{path: "ociVersion"},
+	{path: "process.terminal"},
+	{path: "process.consoleSize"},
+	{path: "process.args"},
+	{path: "process.env"},
+	// processes in the jail start in the jail's root directory
+	{path: "process.cwd", honoured: func(value json.RawMessage) bool {
+		var cwd string
+		return json.Unmarshal(value, &cwd) == nil && cwd == "/"
+	}},
+	// processes in the jail run as root
+	{path: "process.user", honoured: func(value json.RawMessage) bool {
+		user := make(map[string]json.RawMessage)
+		if json.Unmarshal(value, &user) != nil {
+			return false
+		}
+		for _, v := range user {
+			if !isEmptyJSON(v) {
+				return false
+			}
+		}
+		return true
+	}},
+	{path: "root.path"},
+	{path: "annotations"},
+}
+
+// SupportedFields returns the JSON paths of the fields of config.json that runj
+// honours.
+func SupportedFields() []string {
+	paths := make([]string, 0, len(supportedFields))
+	for _, f := range supportedFields {
+		paths = append(paths, f.path)
+	}
+	return paths
+}
+
+// UnsupportedFields returns the JSON paths of the fields set in config.json
+// that runj cannot honour.  Fields set to their zero value are not reported,
+// and an unsupported section is reported once rather than once per field
+// inside it.
+func UnsupportedFields(data []byte) ([]string, error) {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ConfigFileName, err)
+	}
+	var unsupported []string
+	walkUnsupported("", raw, &unsupported)
+	sort.Strings(unsupported)
+	return unsupported, nil
+}
+
+func walkUnsupported(prefix string, fields map[string]json.RawMessage, unsupported *[]string) {
+	for name, value := range fields {
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		if isEmptyJSON(value) {
+			continue
+		}
+		honoured, descend := false, false
+		for _, f := range supportedFields {
+			if f.path == path {
+				honoured = f.honoured == nil || f.honoured(value)
+				break
+			}
+			if strings.HasPrefix(f.path, path+".") {
+				descend = true
+			}
+		}
+		if honoured {
+			continue
+		}
+		if descend {
+			children := make(map[string]json.RawMessage)
+			if json.Unmarshal(value, &children) == nil {
+				walkUnsupported(path, children, unsupported)
+				continue
+			}
+		}
+		*unsupported = append(*unsupported, path)
+	}
+}
+
+// UnsupportedFieldsError is returned in ConfigModeStrict when config.json sets
+// fields that runj cannot honour.
+type UnsupportedFieldsError struct {
+	Fields []string
+}
+
+func (e *UnsupportedFieldsError) Error() string {
+	return fmt.Sprintf("%s sets fields that runj cannot honour: %s", ConfigFileName, strings.Join(e.Fields, ", "))
+}
+
+// CheckUnsupportedFields applies the ConfigMode to the unsupported fields set
+// in config.json.
+func CheckUnsupportedFields(data []byte, mode ConfigMode) error {
+	if mode == ConfigModePermissive {
+		return nil
+	}
+	fields, err := UnsupportedFields(data)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	if mode == ConfigModeStrict {
+		return &UnsupportedFieldsError{Fields: fields}
+	}
+	logrus.WithField("fields", fields).Warnf("ignoring %s fields that runj cannot honour", ConfigFileName)
+	return nil
+}
@@ -0,0 +1,33 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsupportedFields(t *testing.T) {
+	fields, err := UnsupportedFields([]byte(`{
+		"ociVersion": "1.0.2",
+		"process": {
+			"terminal": false,
+			"args": ["sh"],
+			"env": ["PATH=/bin"],
+			"cwd": "/",
+			"user": {"uid": 0, "gid": 0},
+			"noNewPrivileges": true
+		},
+		"root": {"path": "rootfs", "readonly": false},
+		"hostname": "",
+		"mounts": [{"destination": "/proc", "type": "proc"}],
+		"annotations": {"wtf.sbk.runj.config-mode": "strict"},
+		"linux": {"namespaces": [{"type": "pid"}]}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"linux", "mounts", "process.noNewPrivileges"}, fields)
+
+	fields, err = UnsupportedFields([]byte(`{"ociVersion": "1.0.2", "process": {"args": ["sh"], "cwd": "/tmp", "user": {"uid": 1001}}}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"process.cwd", "process.user"}, fields)
+}
@@ -0,0 +1,74 @@
+package oci
+
+import (
+	"strconv"
+	"strings"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/runtimespec"
+)
+
+const (
+	// DefaultPath is the PATH set for processes whose environment has none.
+	// It matches the path for the root user in FreeBSD's default login.conf(5).
+	DefaultPath = "/sbin:/bin:/usr/sbin:/usr/bin:/usr/local/sbin:/usr/local/bin"
+	// DefaultHome is the HOME set for processes whose environment has none.
+	// Processes in the jail run as root.
+	DefaultHome = "/root"
+	// DefaultTerm is the TERM set for processes with a terminal whose
+	// environment has none
+	DefaultTerm = "xterm"
+
+	// ConsoleRawAnnotation is the config.json annotation that puts the
+	// process's console in raw mode when set to "true"
+	ConsoleRawAnnotation = "wtf.sbk.runj.console.raw"
+)
+
+// ProcessEnv returns the environment for process with PATH, HOME, and (when
+// a terminal is used) TERM added if they are not set, as runc does.
+func ProcessEnv(process *runtimespec.Process) []string {
+	env := append([]string(nil), process.Env...)
+	if _, ok := lookupEnv(env, "PATH"); !ok {
+		env = append(env, "PATH="+DefaultPath)
+	}
+	if _, ok := lookupEnv(env, "HOME"); !ok {
+		env = append(env, "HOME="+DefaultHome)
+	}
+	if _, ok := lookupEnv(env, "TERM"); !ok && process.Terminal {
+		env = append(env, "TERM="+DefaultTerm)
+	}
+	return env
+}
+
+// ConsoleRaw reports whether the console should be put in raw mode.
+func ConsoleRaw(annotations map[string]string) bool {
+	raw, _ := strconv.ParseBool(annotations[ConsoleRawAnnotation])
+	return raw
+}
+
+// ExecutableNotFoundError is returned by LookPath when the process's executable
+// cannot be found in the root filesystem.
+type ExecutableNotFoundError = jail.ExecutableNotFoundError
+
+// LookPath finds the executable for process.args[0] in the root filesystem at
+// rootfs, searching the PATH from ProcessEnv like execvp(3) would inside the
+// jail.  Symlinks are followed relative to rootfs.  The returned path is the
+// path inside the jail.  Relative paths are relative to the jail's root
+// directory, where the process is started; process.cwd is not honoured.
+func LookPath(rootfs string, process *runtimespec.Process) (string, error) {
+	if len(process.Args) == 0 || process.Args[0] == "" {
+		return "", &ExecutableNotFoundError{}
+	}
+	path, _ := lookupEnv(ProcessEnv(process), "PATH")
+	return jail.LookPath(rootfs, process.Args[0], "/", path)
+}
+
+// lookupEnv returns the value of the first variable named key in env.
+func lookupEnv(env []string, key string) (string, bool) {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return e[len(key)+1:], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package oci
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.sbk.wtf/runj/runtimespec"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		process runtimespec.Process
+		env     []string
+	}{{
+		name:    "defaults",
+		process: runtimespec.Process{Env: []string{"A=b"}},
+		env:     []string{"A=b", "PATH=" + DefaultPath, "HOME=" + DefaultHome},
+	}, {
+		name:    "terminal",
+		process: runtimespec.Process{Terminal: true},
+		env:     []string{"PATH=" + DefaultPath, "HOME=" + DefaultHome, "TERM=" + DefaultTerm},
+	}, {
+		name:    "set",
+		process: runtimespec.Process{Terminal: true, Env: []string{"PATH=/bin", "HOME=/home", "TERM=vt100"}},
+		env:     []string{"PATH=/bin", "HOME=/home", "TERM=vt100"},
+	}, {
+		name:    "empty",
+		process: runtimespec.Process{Env: []string{"PATH=", "HOME="}},
+		env:     []string{"PATH=", "HOME="},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.env, ProcessEnv(&tc.process))
+		})
+	}
+}
+
+func TestLookPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "runj-lookpath")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"bin", "usr/bin", "usr/local/bin", "opt"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "bin/sh"), nil, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "usr/bin/data"), nil, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "opt/app"), nil, 0755))
+	// absolute and relative links are resolved inside the root
+	require.NoError(t, os.Symlink("/bin/sh", filepath.Join(root, "usr/bin/abs")))
+	require.NoError(t, os.Symlink("../../../../../opt/app", filepath.Join(root, "usr/local/bin/rel")))
+	require.NoError(t, os.Symlink("/usr/bin", filepath.Join(root, "usr/local/bin/dir")))
+	// these exist on the host but not in the root
+	require.NoError(t, os.Symlink(filepath.Join(root, "bin/sh"), filepath.Join(root, "usr/bin/host")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "usr/bin/loop")))
+
+	for _, tc := range []struct {
+		name     string
+		process  runtimespec.Process
+		path     string
+		searched []string
+	}{{
+		name:    "default path",
+		process: runtimespec.Process{Args: []string{"sh"}},
+		path:    "/bin/sh",
+	}, {
+		name:    "spec path",
+		process: runtimespec.Process{Args: []string{"app"}, Env: []string{"PATH=/usr/bin:/opt"}},
+		path:    "/opt/app",
+	}, {
+		name:    "absolute symlink",
+		process: runtimespec.Process{Args: []string{"abs"}, Env: []string{"PATH=/usr/bin"}},
+		path:    "/usr/bin/abs",
+	}, {
+		name:    "relative symlink",
+		process: runtimespec.Process{Args: []string{"/usr/local/bin/rel"}},
+		path:    "/usr/local/bin/rel",
+	}, {
+		name:    "symlinked directory",
+		process: runtimespec.Process{Args: []string{"/usr/local/bin/dir/abs"}},
+		path:    "/usr/local/bin/dir/abs",
+	}, {
+		name:    "relative to root",
+		process: runtimespec.Process{Args: []string{"./opt/app"}, Cwd: "/opt"},
+		path:    "/opt/app",
+	}, {
+		name:     "cwd is not honoured",
+		process:  runtimespec.Process{Args: []string{"./app"}, Cwd: "/opt"},
+		searched: []string{"/app"},
+	}, {
+		name:     "not executable",
+		process:  runtimespec.Process{Args: []string{"data"}, Env: []string{"PATH=/usr/bin"}},
+		searched: []string{"/usr/bin/data"},
+	}, {
+		name:     "host symlink",
+		process:  runtimespec.Process{Args: []string{"host"}, Env: []string{"PATH=/bin:/usr/bin"}},
+		searched: []string{"/bin/host", "/usr/bin/host"},
+	}, {
+		name:     "symlink loop",
+		process:  runtimespec.Process{Args: []string{"/usr/bin/loop"}},
+		searched: []string{"/usr/bin/loop"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			path, err := LookPath(root, &tc.process)
+			if tc.searched == nil {
+				require.NoError(t, err)
+				assert.Equal(t, tc.path, path)
+				return
+			}
+			notFound := &ExecutableNotFoundError{}
+			require.True(t, errors.As(err, &notFound), "error should be an ExecutableNotFoundError: %v", err)
+			assert.Equal(t, tc.process.Args[0], notFound.Name)
+			assert.Equal(t, tc.searched, notFound.Searched)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.sbk.wtf/runj/jail"
+	"go.sbk.wtf/runj/runtimespec"
+)
+
+// FieldError describes a problem with a single field of config.json.
+type FieldError struct {
+	// Field is the JSON path of the field, like "process.env[2]"
+	Field string
+	// Message describes the problem
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError aggregates every problem found in config.json.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return "invalid " + ConfigFileName + ": " + strings.Join(msgs, "; ")
+}
+
+// unsupportedSections are top-level sections of config.json for platforms other
+// than FreeBSD.  The "linux" section is not included as tools commonly generate
+// it for every Unix-like platform.
+var unsupportedSections = []string{"solaris", "windows", "vm"}
+
+// unsupportedLinuxSecurity are Linux-only security settings that cannot be
+// enforced by a jail.  Ignoring them would run the container with weaker
+// isolation than requested.
+var unsupportedLinuxSecurity = []struct {
+	section string
+	field   string
+}{
+	{"linux", "seccomp"},
+	{"process", "apparmorProfile"},
+	{"process", "selinuxLabel"},
+}
+
+// Validate checks the contents of a config.json file and returns a
+// *ValidationError describing every problem found, or nil if the config can be
+// used to create a jail.
+func Validate(data []byte) error {
+	spec := &runtimespec.Spec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return fmt.Errorf("invalid %s: %w", ConfigFileName, err)
+	}
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("invalid %s: %w", ConfigFileName, err)
+	}
+
+	v := &validator{}
+	v.version(spec.Version)
+	v.process(spec.Process)
+	v.root(spec.Root)
+	v.mounts(spec.Mounts)
+	for name, value := range jail.ParametersFromAnnotations(spec.Annotations) {
+		if err := jail.ValidateParameter(name, value); err != nil {
+			v.errorf("annotations."+jail.ParameterAnnotationPrefix+name, "%v", err)
+		}
+	}
+	for _, a := range annotations {
+		if value, ok := spec.Annotations[a.key]; ok {
+			if err := a.validate(value); err != nil {
+				v.errorf("annotations."+a.key, "%v", err)
+			}
+		}
+	}
+	for _, section := range unsupportedSections {
+		if value, ok := raw[section]; ok && !isEmptyJSON(value) {
+			v.errorf(section, "not supported on FreeBSD")
+		}
+	}
+	for _, u := range unsupportedLinuxSecurity {
+		section := make(map[string]json.RawMessage)
+		if sectionData, ok := raw[u.section]; !ok || json.Unmarshal(sectionData, &section) != nil {
+			continue
+		}
+		if value, ok := section[u.field]; ok && !isEmptyJSON(value) {
+			v.errorf(u.section+"."+u.field, "not supported on FreeBSD")
+		}
+	}
+	if len(v.errs) > 0 {
+		return &ValidationError{Errors: v.errs}
+	}
+	return nil
+}
+
+// validator accumulates FieldErrors
+type validator struct {
+	errs []*FieldError
+}
+
+func (v *validator) errorf(field, format string, args ...interface{}) {
+	v.errs = append(v.errs, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) version(version string) {
+	if version == "" {
+		v.errorf("ociVersion", "required")
+		return
+	}
+	parsed, pre, err := parseVersion(version)
+	if err != nil {
+		v.errorf("ociVersion", "%v", err)
+		return
+	}
+	min, _, _ := parseVersion(MinimumVersion)
+	max, _, _ := parseVersion(MaximumVersion)
+	if compareVersion(parsed, min) < 0 || (compareVersion(parsed, min) == 0 && pre) {
+		v.errorf("ociVersion", "%q is older than the minimum supported version %s", version, MinimumVersion)
+	}
+	// minor and patch versions of the spec only add fields, which are
+	// reported as unsupported fields, so any version with the same major
+	// version is accepted
+	if parsed[0] != max[0] {
+		v.errorf("ociVersion", "%q has a different major version than the maximum supported version %s", version, MaximumVersion)
+	}
+}
+
+func (v *validator) process(process *runtimespec.Process) {
+	if process == nil {
+		v.errorf("process", "required")
+		return
+	}
+	if len(process.Args) == 0 || process.Args[0] == "" {
+		v.errorf("process.args", "at least one argument is required")
+	}
+	if process.ConsoleSize != nil && !process.Terminal {
+		v.errorf("process.consoleSize", "requires process.terminal")
+	}
+	if process.Cwd == "" {
+		v.errorf("process.cwd", "required")
+	} else if !filepath.IsAbs(process.Cwd) {
+		v.errorf("process.cwd", "%q must be an absolute path", process.Cwd)
+	}
+	for i, env := range process.Env {
+		if parts := strings.SplitN(env, "=", 2); len(parts) != 2 || parts[0] == "" {
+			v.errorf(fmt.Sprintf("process.env[%d]", i), "%q must be in the form KEY=VALUE", env)
+		}
+	}
+}
+
+func (v *validator) root(root *runtimespec.Root) {
+	if root != nil && root.Path == "" {
+		v.errorf("root.path", "required")
+	}
+}
+
+func (v *validator) mounts(mounts []runtimespec.Mount) {
+	for i, m := range mounts {
+		field := fmt.Sprintf("mounts[%d].destination", i)
+		if m.Destination == "" {
+			v.errorf(field, "required")
+		} else if !filepath.IsAbs(m.Destination) {
+			v.errorf(field, "%q must be an absolute path", m.Destination)
+		}
+	}
+}
+
+// parseVersion parses a semantic version like "1.0.2-dev" into its major,
+// minor, and patch components and reports whether it is a pre-release.
+func parseVersion(version string) ([3]int, bool, error) {
+	var parsed [3]int
+	core := version
+	if i := strings.IndexByte(core, '+'); i >= 0 {
+		core = core[:i]
+	}
+	pre := false
+	if i := strings.IndexByte(core, '-'); i >= 0 {
+		core = core[:i]
+		pre = true
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return parsed, false, fmt.Errorf("%q is not a valid semantic version", version)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, false, fmt.Errorf("%q is not a valid semantic version", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, pre, nil
+}
+
+func compareVersion(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// isEmptyJSON reports whether a raw JSON value is null, false, zero, or an
+// empty string, object, or array.
+func isEmptyJSON(value json.RawMessage) bool {
+	switch strings.TrimSpace(string(value)) {
+	case "", "null", "false", "0", `""`, "{}", "[]":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		fields []string
+	}{{
+		name:   "minimal",
+		config: `{"ociVersion": "1.0.2-dev", "process": {"args": ["sh"], "cwd": "/"}}`,
+	}, {
+		name:   "linux section tolerated",
+		config: `{"ociVersion": "1.0.0", "process": {"args": ["sh"], "cwd": "/"}, "linux": {"namespaces": []}}`,
+	}, {
+		name:   "missing process and version",
+		config: `{}`,
+		fields: []string{"ociVersion", "process"},
+	}, {
+		name:   "old version",
+		config: `{"ociVersion": "1.0.0-rc5", "process": {"args": ["sh"], "cwd": "/"}}`,
+		fields: []string{"ociVersion"},
+	}, {
+		name:   "newer minor version",
+		config: `{"ociVersion": "1.1.0", "process": {"args": ["sh"], "cwd": "/"}}`,
+	}, {
+		name:   "newer minor and patch version",
+		config: `{"ociVersion": "1.2.1", "process": {"args": ["sh"], "cwd": "/"}}`,
+	}, {
+		name:   "new major version",
+		config: `{"ociVersion": "2.0.0", "process": {"args": ["sh"], "cwd": "/"}}`,
+		fields: []string{"ociVersion"},
+	}, {
+		name:   "missing cwd",
+		config: `{"ociVersion": "1.0.2", "process": {"args": ["sh"]}}`,
+		fields: []string{"process.cwd"},
+	}, {
+		name: "aggregated field errors",
+		config: `{
+			"ociVersion": "1.0.2",
+			"process": {"args": [], "cwd": "tmp", "env": ["PATH=/bin", "NOVALUE", "=x"]},
+			"root": {"path": ""},
+			"mounts": [{"destination": "/ok"}, {"destination": "relative"}, {}],
+			"windows": {"layerFolders": []},
+			"linux": {"seccomp": {"defaultAction": "SCMP_ACT_ERRNO"}}
+		}`,
+		fields: []string{
+			"process.args",
+			"process.cwd",
+			"process.env[1]",
+			"process.env[2]",
+			"root.path",
+			"mounts[1].destination",
+			"mounts[2].destination",
+			"windows",
+			"linux.seccomp",
+		},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate([]byte(tc.config))
+			if len(tc.fields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.IsType(t, &ValidationError{}, err)
+			var fields []string
+			for _, fe := range err.(*ValidationError).Errors {
+				fields = append(fields, fe.Field)
+			}
+			assert.Equal(t, tc.fields, fields)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+// Package procctl controls how orphaned processes are reparented.
+package procctl
+
+import "errors"
+
+// ErrUnsupported is returned on platforms where a process cannot become the
+// reaper of its descendants.
+var ErrUnsupported = errors.New("procctl: reaper is not supported on this platform")
@@ -0,0 +1,19 @@
+package procctl
+
+import "golang.org/x/sys/unix"
+
+const (
+	_P_PID             = 0 // https://github.com/freebsd/freebsd-src/blob/098dbd7ff7f3da9dda03802cdb2d8755f816eada/sys/sys/wait.h#L109
+	_PROC_REAP_ACQUIRE = 2 // https://github.com/freebsd/freebsd-src/blob/098dbd7ff7f3da9dda03802cdb2d8755f816eada/sys/sys/procctl.h#L49
+)
+
+// ReapAcquire makes the current process the reaper for its orphaned
+// descendants with procctl(2) PROC_REAP_ACQUIRE.
+func ReapAcquire() error {
+	pid := unix.Getpid()
+	_, _, errno := unix.Syscall(unix.SYS_PROCCTL, _P_PID, uintptr(pid), _PROC_REAP_ACQUIRE)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package procctl
+
+import "golang.org/x/sys/unix"
+
+// ReapAcquire makes the current process the reaper for its orphaned
+// descendants with prctl(2) PR_SET_CHILD_SUBREAPER.
+func ReapAcquire() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
@@ -0,0 +1,9 @@
+//go:build !freebsd && !linux
+// +build !freebsd,!linux
+
+package procctl
+
+// ReapAcquire returns ErrUnsupported.
+func ReapAcquire() error {
+	return ErrUnsupported
+}
@@ -28,17 +28,17 @@ type Spec struct {
 	Process *Process `json:"process,omitempty"`
 	// Root configures the container's root filesystem.
 	Root *Root `json:"root,omitempty"`
+	// Hostname configures the container's hostname.
+	Hostname string `json:"hostname,omitempty"`
+	// Mounts configures additional mounts (on top of Root).
+	Mounts []Mount `json:"mounts,omitempty"`
+	// Annotations contains arbitrary metadata for the container.
+	Annotations map[string]string `json:"annotations,omitempty"`
 
 	// Modification by Samuel Karp
 	/*
-		// Hostname configures the container's hostname.
-		Hostname string `json:"hostname,omitempty"`
-		// Mounts configures additional mounts (on top of Root).
-		Mounts []Mount `json:"mounts,omitempty"`
 		// Hooks configures callbacks for container lifecycle events.
 		Hooks *Hooks `json:"hooks,omitempty" platform:"linux,solaris"`
-		// Annotations contains arbitrary metadata for the container.
-		Annotations map[string]string `json:"annotations,omitempty"`
 
 		// Linux is platform-specific configuration for Linux based containers.
 		Linux *Linux `json:"linux,omitempty" platform:"linux"`
@@ -56,19 +56,26 @@ type Spec struct {
 /*
 Omitted type definitions for:
 LinuxCapabilities
-Box
 User
 */
 // End of modification
 
+// Box specifies dimensions of a rectangle. Used for specifying the size of a console.
+type Box struct {
+	// Height is the vertical dimension of a box.
+	Height uint `json:"height"`
+	// Width is the horizontal dimension of a box.
+	Width uint `json:"width"`
+}
+
 // Process contains information to start a specific application inside the container.
 type Process struct {
 	// Terminal creates an interactive terminal for the container.
 	Terminal bool `json:"terminal,omitempty"`
+	// ConsoleSize specifies the size of the console.
+	ConsoleSize *Box `json:"consoleSize,omitempty"`
 	// Modification by Samuel Karp
 	/*
-		// ConsoleSize specifies the size of the console.
-		ConsoleSize *Box `json:"consoleSize,omitempty"`
 		// User specifies user information for the process.
 		User User `json:"user"`
 	*/
@@ -86,11 +93,12 @@ type Process struct {
 	// Env populates the process environment for the process.
 	Env []string `json:"env,omitempty"`
 
+	// Cwd is the current working directory for the process and must be
+	// relative to the container's root.
+	Cwd string `json:"cwd"`
+
 	// Modification by Samuel Karp`
 	/*
-		// Cwd is the current working directory for the process and must be
-		// relative to the container's root.
-		Cwd string `json:"cwd"`
 		// Capabilities are Linux capabilities that are kept for the process.
 		Capabilities *LinuxCapabilities `json:"capabilities,omitempty" platform:"linux"`
 		// Rlimits specifies rlimit options to apply to the process.
@@ -120,10 +128,21 @@ type Root struct {
 	// End of modification
 }
 
+// Mount specifies a mount for a container.
+type Mount struct {
+	// Destination is the absolute path where the mount will be placed in the container.
+	Destination string `json:"destination"`
+	// Type specifies the mount kind.
+	Type string `json:"type,omitempty" platform:"linux,solaris"`
+	// Source specifies the source path of the mount.
+	Source string `json:"source,omitempty"`
+	// Options are fstab style mount options.
+	Options []string `json:"options,omitempty"`
+}
+
 // Modification by Samuel Karp
 /*
 Omitted type definitions for:
-Mount
 Hook
 Hooks
 Linux
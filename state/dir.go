@@ -2,19 +2,35 @@ package state
 
 import (
 	"os"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
-const (
-	defaultStateDir = "/var/lib/runj/jails"
-	stateDir        = defaultStateDir
-)
+// DefaultRoot is the directory where state for all jails is kept unless
+// changed with SetRoot.
+const DefaultRoot = "/var/lib/runj/jails"
+
+var stateDir = DefaultRoot
+
+// SetRoot changes the directory where state for all jails is kept.
+func SetRoot(root string) {
+	stateDir = root
+}
+
+// Root returns the directory where state for all jails is kept.
+func Root() string {
+	return stateDir
+}
 
 func Create(id, bundle string) (*State, error) {
 	s := &State{
-		ID:     id,
-		Bundle: bundle,
-		Status: StatusCreating,
+		ID:      id,
+		Bundle:  bundle,
+		Status:  StatusCreating,
+		Created: time.Now(),
+		Owner:   owner(),
 	}
 	err := os.MkdirAll(Dir(id), 0755)
 	if err != nil {
@@ -34,3 +50,13 @@ func Dir(id string) string {
 func Remove(id string) error {
 	return os.RemoveAll(Dir(id))
 }
+
+// owner returns the name of the current user, or the uid if the user cannot be
+// looked up.
+func owner() string {
+	u, err := user.Current()
+	if err != nil {
+		return strconv.Itoa(os.Getuid())
+	}
+	return u.Username
+}
@@ -0,0 +1,43 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// migrations upgrade a state.json from schema version i+1, where i is their
+// index, to the next version.
+//
+// Files written before versioning was introduced have no version and are
+// loaded as version 1.  They use the Go field names of State as keys, which
+// differ from the version 1 keys only in case, and encoding/json matches keys
+// case-insensitively.
+var migrations []func(map[string]json.RawMessage) error
+
+// migrate upgrades the contents of a state.json to the current schema version.
+func migrate(d []byte) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(d, &fields); err != nil {
+		return nil, err
+	}
+	version := 1
+	v, ok := fields["version"]
+	if ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("state: invalid version: %w", err)
+		}
+		if version == schemaVersion {
+			return d, nil
+		}
+	}
+	if version < 1 || version > schemaVersion {
+		return nil, fmt.Errorf("state: unsupported version %d, expected at most %d", version, schemaVersion)
+	}
+	for ; version < schemaVersion; version++ {
+		if err := migrations[version-1](fields); err != nil {
+			return nil, fmt.Errorf("state: failed to migrate from version %d: %w", version, err)
+		}
+	}
+	fields["version"] = json.RawMessage(fmt.Sprint(schemaVersion))
+	return json.Marshal(fields)
+}
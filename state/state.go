@@ -2,9 +2,13 @@ package state
 
 import (
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 const stateFile = "state.json"
@@ -18,12 +22,69 @@ const (
 	StatusStopped  Status = "stopped"
 )
 
+// schemaVersion is the version of the state.json format written by Save.  It
+// must be incremented, and a migration added to migrations, whenever a change to
+// State would prevent an older state.json from being loaded correctly.
+const schemaVersion = 1
+
 type State struct {
-	ID     string
-	JID    int
-	Status Status
-	Bundle string
-	PID    int
+	// Version is the schema version of the state.json the State was loaded
+	// from.  Save always writes the current schema version.
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	JID     int    `json:"jid"`
+	Status  Status `json:"status"`
+	Bundle  string `json:"bundle"`
+	PID     int    `json:"pid"`
+	// Backend is the name of the jail.Backend that created the jail.  An
+	// empty Backend means the jail was created with jail(8).
+	Backend string `json:"backend,omitempty"`
+	// Annotations are the annotations from the container's config.json
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// OOMs counts the rctl(8) memory limit notifications received for the
+	// jail
+	OOMs int `json:"ooms"`
+	// LastOOM is the time of the most recent rctl(8) memory limit
+	// notification
+	LastOOM time.Time `json:"lastOOM"`
+	// Created is the time the container was created
+	Created time.Time `json:"created"`
+	// Owner is the name of the user that created the container
+	Owner string `json:"owner"`
+	// Rootfs is the absolute path to the jail's root filesystem with symlinks
+	// resolved
+	Rootfs string `json:"rootfs"`
+	// ConfigDigest is the digest of the container's config.json
+	ConfigDigest string `json:"configDigest"`
+	// ConsolePath is the path of the pseudoterminal slave allocated for the
+	// container process, if process.terminal is set
+	ConsolePath string `json:"consolePath,omitempty"`
+	// ExitStatus is the exit status of the container process.  It is only
+	// meaningful when ExitedAt is set.
+	ExitStatus int `json:"exitStatus"`
+	// ExitedAt is the time the container process exited, if its exit was
+	// recorded by RecordExit
+	ExitedAt time.Time `json:"exitedAt"`
+}
+
+// List returns the IDs of all containers with state.
+func List() ([]string, error) {
+	entries, err := ioutil.ReadDir(stateDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(stateDir, e.Name(), stateFile)); err == nil {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
 }
 
 func Load(id string) (*State, error) {
@@ -31,6 +92,10 @@ func Load(id string) (*State, error) {
 	if err != nil {
 		return nil, err
 	}
+	d, err = migrate(d)
+	if err != nil {
+		return nil, err
+	}
 	s := &State{}
 	err = json.Unmarshal(d, s)
 	if err != nil {
@@ -39,36 +104,151 @@ func Load(id string) (*State, error) {
 	return s, nil
 }
 
+// Update loads the state for id, calls fn to modify it, and saves it while
+// holding an exclusive flock(2) on the state directory.  runj commands, the
+// monitor, and the containerd shim all update the state, so modifications that
+// depend on the current state must use Update to avoid losing each other's
+// changes.  The state is not saved if fn returns an error.
+func Update(id string, fn func(*State) error) error {
+	unlock, err := lock(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s, err := Load(id)
+	if err != nil {
+		return err
+	}
+	if err := fn(s); err != nil {
+		return err
+	}
+	return s.Save()
+}
+
+// lock takes an exclusive flock(2) on the state directory for id and returns a
+// function that releases it.
+func lock(id string) (func(), error) {
+	d, err := os.Open(Dir(id))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = unix.Flock(int(d.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	// closing the directory releases the lock
+	return func() { d.Close() }, nil
+}
+
+// RecordOOM records that an rctl(8) memory limit notification was received for
+// the jail at time t.
+func RecordOOM(id string, t time.Time) error {
+	return Update(id, func(s *State) error {
+		s.OOMs++
+		s.LastOOM = t
+		return nil
+	})
+}
+
+// RecordExit records that the container process exited with status at time t
+// and marks the container stopped.
+func RecordExit(id string, status int, t time.Time) error {
+	return Update(id, func(s *State) error {
+		s.Status = StatusStopped
+		s.PID = 0
+		s.ExitStatus = status
+		s.ExitedAt = t
+		return nil
+	})
+}
+
 // initialize creates the original state file, checking for existence and
 // failing if one already exists.  Initialize should be used as a guard to
 // prevent overwriting a state file for an existing container.
 func (s *State) initialize() error {
-	_, err := os.OpenFile(filepath.Join(Dir(s.ID), stateFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	f, err := os.OpenFile(filepath.Join(Dir(s.ID), stateFile), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
 	if err != nil {
 		return err
 	}
+	if err := f.Close(); err != nil {
+		return err
+	}
 	return s.Save()
 }
 
-func (s *State) Save() error {
-	f, err := ioutil.TempFile(Dir(s.ID), "state")
+// tempFile is the subset of *os.File used by Save
+type tempFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+	Name() string
+}
+
+// fsOps are the filesystem operations used by Save.  They are variables so that
+// tests can inject failures.
+var fsOps = struct {
+	tempFile func(dir, pattern string) (tempFile, error)
+	rename   func(oldpath, newpath string) error
+	syncDir  func(dir string) error
+}{
+	tempFile: func(dir, pattern string) (tempFile, error) { return ioutil.TempFile(dir, pattern) },
+	rename:   os.Rename,
+	syncDir:  syncDir,
+}
+
+// Save atomically replaces the state file.  The new state is written to a
+// temporary file which is synced to disk before it is renamed over the state
+// file, and the directory is synced afterwards so that the rename is durable.
+// A crash at any point leaves either the old or the new state file in place.
+func (s *State) Save() (err error) {
+	dir := Dir(s.ID)
+	f, err := fsOps.tempFile(dir, "state")
 	if err != nil {
 		return err
 	}
+	closed := false
 	defer func() {
-		f.Close()
-		if err != nil {
-			os.Remove(f.Name())
+		if err == nil {
+			return
 		}
+		if !closed {
+			f.Close()
+		}
+		os.Remove(f.Name())
 	}()
+	s.Version = schemaVersion
 	d, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
-	_, err = f.Write(d)
+	if _, err = f.Write(d); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	closed = true
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = fsOps.rename(f.Name(), filepath.Join(dir, stateFile)); err != nil {
+		return err
+	}
+	return fsOps.syncDir(dir)
+}
+
+// syncDir flushes the directory entries of dir to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
-	os.Rename(f.Name(), filepath.Join(Dir(s.ID), stateFile))
-	return nil
+	defer d.Close()
+	return d.Sync()
 }
@@ -0,0 +1,213 @@
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useFixture sets up a temporary state root containing the fixture in testdata
+// as the state.json for id.
+func useFixture(t *testing.T, id, fixture string) {
+	root, err := ioutil.TempDir("", "runj-state")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		os.RemoveAll(root)
+		SetRoot(DefaultRoot)
+	})
+	SetRoot(root)
+	require.NoError(t, os.MkdirAll(Dir(id), 0755))
+	if fixture == "" {
+		return
+	}
+	d, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	require.NoError(t, err, "test data")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(Dir(id), stateFile), d, 0600))
+}
+
+// unversioned.json was written by Save before state.json was versioned, when
+// State had only the ID, JID, Status, Bundle, and PID fields and no JSON tags.
+func TestLoadUnversioned(t *testing.T) {
+	useFixture(t, "unversioned", "unversioned.json")
+	s, err := Load("unversioned")
+	require.NoError(t, err)
+	assert.Equal(t, &State{
+		Version: schemaVersion,
+		ID:      "unversioned",
+		JID:     3,
+		Status:  StatusRunning,
+		Bundle:  "/tmp/bundle",
+		PID:     1234,
+	}, s)
+}
+
+func TestSaveWritesCurrentVersion(t *testing.T) {
+	useFixture(t, "unversioned", "unversioned.json")
+	s, err := Load("unversioned")
+	require.NoError(t, err)
+	require.NoError(t, s.Save())
+	d, err := ioutil.ReadFile(filepath.Join(Dir("unversioned"), stateFile))
+	require.NoError(t, err)
+	assert.Contains(t, string(d), `"version":1`)
+	assert.Contains(t, string(d), `"id":"unversioned"`)
+	reloaded, err := Load("unversioned")
+	require.NoError(t, err)
+	assert.Equal(t, s, reloaded)
+}
+
+func TestLoadNewerVersion(t *testing.T) {
+	useFixture(t, "newer", "")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(Dir("newer"), stateFile), []byte(`{"version":99,"id":"newer"}`), 0600))
+	_, err := Load("newer")
+	assert.Error(t, err)
+}
+
+func TestLoadInvalidVersion(t *testing.T) {
+	useFixture(t, "invalid", "")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(Dir("invalid"), stateFile), []byte(`{"version":0,"id":"invalid"}`), 0600))
+	_, err := Load("invalid")
+	assert.Error(t, err)
+}
+
+// faultyFile is a tempFile that fails at a chosen step.  A failed write stores
+// half of the data to simulate a torn write.
+type faultyFile struct {
+	*os.File
+	failAt string
+}
+
+func (f *faultyFile) Write(b []byte) (int, error) {
+	if f.failAt == "write" {
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("injected write failure")
+	}
+	return f.File.Write(b)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.failAt == "sync" {
+		return errors.New("injected sync failure")
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Close() error {
+	err := f.File.Close()
+	if f.failAt == "close" {
+		return errors.New("injected close failure")
+	}
+	return err
+}
+
+func TestSaveFailures(t *testing.T) {
+	saved := fsOps
+	t.Cleanup(func() { fsOps = saved })
+
+	for _, step := range []string{"tempfile", "write", "sync", "close", "rename", "syncdir"} {
+		t.Run(step, func(t *testing.T) {
+			fsOps = saved
+			useFixture(t, "unversioned", "unversioned.json")
+			old, err := Load("unversioned")
+			require.NoError(t, err)
+
+			fsOps.tempFile = func(dir, pattern string) (tempFile, error) {
+				if step == "tempfile" {
+					return nil, errors.New("injected tempfile failure")
+				}
+				f, err := ioutil.TempFile(dir, pattern)
+				if err != nil {
+					return nil, err
+				}
+				return &faultyFile{File: f, failAt: step}, nil
+			}
+			fsOps.rename = func(oldpath, newpath string) error {
+				if step == "rename" {
+					return errors.New("injected rename failure")
+				}
+				return os.Rename(oldpath, newpath)
+			}
+			fsOps.syncDir = func(dir string) error {
+				if step == "syncdir" {
+					return errors.New("injected syncdir failure")
+				}
+				return syncDir(dir)
+			}
+
+			updated := *old
+			updated.Status = StatusStopped
+			updated.PID = 0
+			assert.Error(t, updated.Save())
+
+			// the state file is never torn: it is either the old or the new
+			// state, and only a failure after the rename exposes the new one
+			s, err := Load("unversioned")
+			require.NoError(t, err)
+			if step == "syncdir" {
+				assert.Equal(t, &updated, s)
+			} else {
+				assert.Equal(t, old, s)
+			}
+
+			// temporary files are cleaned up
+			entries, err := ioutil.ReadDir(Dir("unversioned"))
+			require.NoError(t, err)
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			assert.Equal(t, []string{stateFile}, names)
+		})
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	useFixture(t, "unversioned", "unversioned.json")
+	exitedAt := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	const ooms = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, ooms+1)
+	for i := 0; i < ooms; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- RecordOOM("unversioned", exitedAt)
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errs <- RecordExit("unversioned", 3, exitedAt)
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	// no update is lost
+	s, err := Load("unversioned")
+	require.NoError(t, err)
+	assert.Equal(t, ooms, s.OOMs)
+	assert.Equal(t, StatusStopped, s.Status)
+	assert.Equal(t, 3, s.ExitStatus)
+	assert.Equal(t, exitedAt, s.ExitedAt)
+}
+
+func TestUpdateError(t *testing.T) {
+	useFixture(t, "unversioned", "unversioned.json")
+	err := Update("unversioned", func(s *State) error {
+		s.Status = StatusStopped
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	s, err := Load("unversioned")
+	require.NoError(t, err)
+	assert.Equal(t, StatusRunning, s.Status, "the state should not be saved")
+}